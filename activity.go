@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+// number of RPC handled remembered for the admin API
+const activityHistory = 100
+
+// an RPC handled by ProcessMsgInOut
+type activityItem struct {
+	Time   int64  `json:"time"` // unix time
+	Method string `json:"method"`
+	Id     string `json:"id"`
+	Peer   string `json:"peer"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ring buffer of recent activity
+type activityLog struct {
+	lock  sync.Mutex
+	items []activityItem
+	next  int
+}
+
+var rpcActivity = &activityLog{}
+
+// AUDIT responded with a proof, or with an error
+var auditPassed, auditFailed uint64
+
+func (a *activityLog) add(item activityItem) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.items) < activityHistory {
+		a.items = append(a.items, item)
+		return
+	}
+	a.items[a.next] = item
+	a.next = (a.next + 1) % activityHistory
+}
+
+// recent activity, latest first
+func (a *activityLog) Recent() []activityItem {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	items := make([]activityItem, 0, len(a.items))
+	for i := len(a.items) - 1; i >= 0; i-- {
+		items = append(items, a.items[(a.next+i)%len(a.items)])
+	}
+	return items
+}
+
+// record request m and response res
+func recordActivity(m *MsgInOut, res IMessage) {
+	method, _ := m.MsgInMap()["method"].(string)
+	item := activityItem{
+		Time:   time.Now().Unix(),
+		Method: method,
+		Id:     res.GetId(),
+	}
+	if req, ok := m.MsgInStruct().(IRequest); ok {
+		item.Peer = req.GetContact().NodeID
+	}
+	if resErr, ok := res.(*msg.ResErr); ok {
+		item.Error = resErr.Error.Message
+	}
+	rpcActivity.add(item)
+}
+
+// record response res to verified AUDIT audit
+func recordAudit(audit *msg.Audit, res IMessage) {
+	e := EventAudit{Passed: true}
+	if resErr, ok := res.(*msg.ResErr); ok {
+		e.Passed = false
+		e.Error = resErr.Error.Message
+	}
+	if e.Passed {
+		atomic.AddUint64(&auditPassed, 1)
+	} else {
+		atomic.AddUint64(&auditFailed, 1)
+	}
+	if len(audit.Params.Audits) != 0 {
+		e.DataHash = audit.Params.Audits[0].DataHash
+	}
+	Events.Publish(e)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+	"github.com/boltdb/bolt"
+)
+
+var startTime = time.Now()
+
+// number of events remembered for the admin API
+const eventHistory = 100
+
+// an event published on the bus
+type eventItem struct {
+	Time  int64  `json:"time"` // unix time
+	Name  string `json:"name"`
+	Event Event  `json:"event"`
+}
+
+// ring buffer of recent events
+type eventLog struct {
+	lock  sync.Mutex
+	items []eventItem
+	next  int
+}
+
+func (l *eventLog) add(e Event) {
+	item := eventItem{Time: time.Now().Unix(), Name: e.EventName(), Event: e}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.items) < eventHistory {
+		l.items = append(l.items, item)
+		return
+	}
+	l.items[l.next] = item
+	l.next = (l.next + 1) % eventHistory
+}
+
+// recent events, latest first
+func (l *eventLog) Recent() []eventItem {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	items := make([]eventItem, 0, len(l.items))
+	for i := len(l.items) - 1; i >= 0; i-- {
+		items = append(items, l.items[(l.next+i)%len(l.items)])
+	}
+	return items
+}
+
+// summary of a contract for the admin API
+type contractInfo struct {
+	DataHash  string `json:"data_hash"`
+	RenterID  string `json:"renter_id"`
+	DataSize  int64  `json:"data_size"`
+	StoreEnd  int64  `json:"store_end"` // milliseconds
+	Trees     int    `json:"trees"`
+	ShardSize int64  `json:"shard_size"` // -1 if no shard
+}
+
+func newContractInfo(dataHash string, sItem storageItem) contractInfo {
+	shardSize, err := Shards.Stat(dataHash)
+	if err != nil {
+		shardSize = -1
+	}
+	return contractInfo{
+		DataHash:  dataHash,
+		RenterID:  sItem.Contract.RenterID,
+		DataSize:  int64(sItem.Contract.DataSize),
+		StoreEnd:  int64(sItem.Contract.StoreEnd),
+		Trees:     len(sItem.Trees),
+		ShardSize: shardSize,
+	}
+}
+
+// local admin API, JSON over a separate listener bound to loopback.
+// events are recorded until ctx is done.
+func AdminHandler(ctx context.Context, node INode) http.Handler {
+	events := &eventLog{}
+	Events.Handle(ctx, 1000, func(e Event) {
+		// logs are in the log file
+		if _, ok := e.(EventLog); !ok {
+			events.add(e)
+		}
+	})
+
+	handler := &RegexpHandler{}
+	handler.HandleFunc(regexp.MustCompile(`^/status$`), adminStatus(node))
+	handler.HandleFunc(regexp.MustCompile(`^/contracts$`), adminContracts)
+	handler.HandleFunc(regexp.MustCompile(`^/contracts/\w+$`), adminContract)
+	handler.HandleFunc(regexp.MustCompile(`^/activity$`), func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, rpcActivity.Recent())
+	})
+	handler.HandleFunc(regexp.MustCompile(`^/transfers$`), func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, activeTransfers())
+	})
+	handler.HandleFunc(regexp.MustCompile(`^/mirrors$`), adminMirrors)
+	handler.HandleFunc(regexp.MustCompile(`^/events$`), func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, events.Recent())
+	})
+	return adminAuth(Cfg.AdminToken, handler)
+}
+
+// reject requests not from loopback, or without "Authorization: Bearer <token>"
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !net.ParseIP(host).IsLoopback() {
+			writeJson(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+			writeJson(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if r.Method != "GET" {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	w.Write(js)
+}
+
+func adminStatus(node INode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usage, err := getStorageUsage()
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, struct {
+			Contact         msg.Contact `json:"contact"`
+			Network         string      `json:"network"`
+			UpTime          int64       `json:"up_time"` // seconds
+			Contracts       int64       `json:"contracts"`
+			ContractBytes   int64       `json:"contract_bytes"`
+			AuditsPassed    uint64      `json:"audits_passed"`
+			AuditsFailed    uint64      `json:"audits_failed"`
+			Uploads         int         `json:"uploads"`
+			Downloads       int         `json:"downloads"`
+			UploadedBytes   int64       `json:"uploaded_bytes"`
+			DownloadedBytes int64       `json:"downloaded_bytes"`
+		}{
+			Contact:         node.Contact(),
+			Network:         node.NetState().String(),
+			UpTime:          int64(time.Since(startTime).Seconds()),
+			Contracts:       usage.Contracts,
+			ContractBytes:   usage.ContractBytes,
+			AuditsPassed:    atomic.LoadUint64(&auditPassed),
+			AuditsFailed:    atomic.LoadUint64(&auditFailed),
+			Uploads:         uploadSlots.Active(),
+			Downloads:       downloadSlots.Active(),
+			UploadedBytes:   uploadMeter.Total(),
+			DownloadedBytes: downloadMeter.Total(),
+		})
+	}
+}
+
+func adminContracts(w http.ResponseWriter, r *http.Request) {
+	infos := []contractInfo{}
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(BucketContract)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var sItem storageItem
+			if err := json.Unmarshal(v, &sItem); err == nil {
+				infos = append(infos, newContractInfo(string(k), sItem))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, infos)
+}
+
+func adminContract(w http.ResponseWriter, r *http.Request) {
+	dataHash := r.URL.Path[len("/contracts/"):]
+	sItem, err := getStorageItem(dataHash)
+	if err != nil {
+		writeJson(w, http.StatusNotFound, map[string]string{"error": "contract not found"})
+		return
+	}
+	writeJson(w, http.StatusOK, struct {
+		contractInfo
+		Contract msg.Contract `json:"contract"`
+	}{newContractInfo(dataHash, sItem), sItem.Contract})
+}
+
+func adminMirrors(w http.ResponseWriter, r *http.Request) {
+	jobs, err := listMirrorJobs()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, jobs)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	const token = "0123456789abcdef"
+	handler := adminAuth(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		remoteAddr string
+		auth       string
+		code       int
+	}{
+		{"no token", "GET", "127.0.0.1:50000", "", http.StatusUnauthorized},
+		{"wrong token", "GET", "127.0.0.1:50000", "Bearer fedcba9876543210", http.StatusUnauthorized},
+		{"token prefix", "GET", "127.0.0.1:50000", "Bearer " + token[:8], http.StatusUnauthorized},
+		{"empty token", "GET", "127.0.0.1:50000", "Bearer ", http.StatusUnauthorized},
+		{"not bearer", "GET", "127.0.0.1:50000", "Basic " + token, http.StatusUnauthorized},
+		{"not loopback", "GET", "192.0.2.1:50000", "Bearer " + token, http.StatusForbidden},
+		{"not loopback ipv6", "GET", "[2001:db8::1]:50000", "Bearer " + token, http.StatusForbidden},
+		{"not get", "POST", "127.0.0.1:50000", "Bearer " + token, http.StatusMethodNotAllowed},
+		{"ok", "GET", "127.0.0.1:50000", "Bearer " + token, http.StatusOK},
+		{"ok ipv6", "GET", "[::1]:50000", "Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/status", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%v: status %v, want %v", tt.name, w.Code, tt.code)
+		}
+	}
+}
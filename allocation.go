@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// running totals of data_size of contracts and of shards stored, loaded
+// by loadStorageTotals and updated where contracts and shards are saved
+// and deleted, so that offers don't scan the storage
+var committedBytes, storedBytes int64
+
+func loadStorageTotals(f *Farmer) error {
+	stored, err := Shards.Size()
+	if err != nil {
+		return fmt.Errorf("get shards size error: %v", err)
+	}
+	atomic.StoreInt64(&committedBytes, f.getContractSize())
+	atomic.StoreInt64(&storedBytes, stored)
+	return nil
+}
+
+func addCommittedBytes(n int64) {
+	atomic.AddInt64(&committedBytes, n)
+}
+
+func addStoredBytes(n int64) {
+	atomic.AddInt64(&storedBytes, n)
+}
+
+// bytes of offers sent but not answered yet, by data_hash
+var pendingOffers = struct {
+	sync.Mutex
+	sizes map[string]int64
+}{sizes: make(map[string]int64)}
+
+func pendingOfferSize() int64 {
+	var size int64 = 0
+	for _, s := range pendingOffers.sizes {
+		size += s
+	}
+	return size
+}
+
+// reserve space for an offer, fails if the offer would overcommit
+// storage_allocation or the free space of data_dir
+func (f *Farmer) reserveOffer(dataHash string, dataSize int64) error {
+	pendingOffers.Lock()
+	defer pendingOffers.Unlock()
+
+	committed := atomic.LoadInt64(&committedBytes)
+	pending := pendingOfferSize()
+
+	// storage allocation
+	allocation := Cfg.GetStorageAllocation()
+	if allocation > 0 && committed+pending+dataSize > allocation {
+		return fmt.Errorf("storage allocation exceeded: committed %v, pending %v, allocation %v",
+			humanizeSize(committed), humanizeSize(pending), humanizeSize(allocation))
+	}
+
+	// free space, minus what's committed but not yet stored
+	free, err := diskFree(Cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("get free space error: %v", err)
+	}
+	outstanding := committed - atomic.LoadInt64(&storedBytes)
+	if outstanding < 0 {
+		outstanding = 0
+	}
+	if outstanding+pending+dataSize > free {
+		return fmt.Errorf("not enough free space: outstanding %v, pending %v, free %v",
+			humanizeSize(outstanding), humanizeSize(pending), humanizeSize(free))
+	}
+
+	pendingOffers.sizes[dataHash] = dataSize
+	return nil
+}
+
+// release space reserved by reserveOffer, after the offer
+// is saved as a contract or failed
+func releaseOffer(dataHash string) {
+	pendingOffers.Lock()
+	defer pendingOffers.Unlock()
+	delete(pendingOffers.sizes, dataHash)
+}
@@ -10,17 +10,32 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
 	log "github.com/inconshreveable/log15"
 )
 
+// options of start command
+type startOptions struct {
+	NoUi      bool   // run as daemon without terminal ui
+	LogStdout bool   // log to stdout instead of log file, daemon mode only
+	PidFile   string // write pid here if set
+}
+
+var StartOpts startOptions
+
 func ParseCmdArgs() {
 	const helpMsg = `usage: go-farmer <command> [<args>]
 Commands:
-	new      create a new configuration file
-	start    start a farmer instance
+	new        create a new configuration file
+	start      start a farmer instance
+	repair     check consistency of contracts and shards
+	mirrors    list mirror jobs and their status
+	contracts  list or show contracts
+	shards     verify shards against their data_hash
+	tokens     list upload and download tokens
 
 See "go-farmer help <command>" for information on a specific command.
 `
@@ -28,12 +43,34 @@ See "go-farmer help <command>" for information on a specific command.
 	startCmd := flag.NewFlagSet("start", flag.ExitOnError)
 	// -config
 	sConfigPath := startCmd.String("config", "./config.json", "config file path")
+	// -no-ui
+	startCmd.BoolVar(&StartOpts.NoUi, "no-ui", false, "run as daemon without terminal ui, status is logged and saved in data_dir/status.json")
+	// -log-stdout
+	startCmd.BoolVar(&StartOpts.LogStdout, "log-stdout", false, "log to stdout instead of log_dir, requires -no-ui")
+	// -pid-file
+	startCmd.StringVar(&StartOpts.PidFile, "pid-file", "", "write process id to file")
 
 	/* new-account command */
 	newAccountCmd := flag.NewFlagSet("new", flag.ExitOnError)
 	// -config
 	sNewConfigPath := newAccountCmd.String("config", "./", "config file path")
 
+	/* repair command */
+	repairCmd := flag.NewFlagSet("repair", flag.ExitOnError)
+	// -config
+	rConfigPath := repairCmd.String("config", "./config.json", "config file path")
+	// -action
+	rAction := repairCmd.String("action", "report", "report, quarantine or delete orphan shards")
+	// -json
+	rJson := repairCmd.Bool("json", false, "print report as json")
+
+	/* mirrors command */
+	mirrorsCmd := flag.NewFlagSet("mirrors", flag.ExitOnError)
+	// -config
+	mConfigPath := mirrorsCmd.String("config", "./config.json", "config file path")
+	// -json
+	mJson := mirrorsCmd.Bool("json", false, "print jobs as json")
+
 	if len(os.Args) == 1 {
 		fmt.Print(helpMsg)
 		os.Exit(2)
@@ -42,11 +79,29 @@ See "go-farmer help <command>" for information on a specific command.
 	switch os.Args[1] {
 	case "start":
 		_ = startCmd.Parse(os.Args[2:])
+		if StartOpts.LogStdout && !StartOpts.NoUi {
+			fmt.Println("-log-stdout requires -no-ui")
+			os.Exit(2)
+		}
 		parseConfigFile(sConfigPath)
+		printConfig()
 	case "new":
 		_ = newAccountCmd.Parse(os.Args[2:])
 		doCreateCfgfile(sNewConfigPath)
 		os.Exit(0)
+	case "repair":
+		_ = repairCmd.Parse(os.Args[2:])
+		parseConfigFile(rConfigPath)
+		doRepair(*rAction, *rJson)
+	case "mirrors":
+		_ = mirrorsCmd.Parse(os.Args[2:])
+		doListMirrors(mConfigPath, *mJson)
+	case "contracts":
+		doContracts(os.Args[2:])
+	case "shards":
+		doShards(os.Args[2:])
+	case "tokens":
+		doTokens(os.Args[2:])
 	case "help":
 		if len(os.Args) != 3 {
 			fmt.Print(helpMsg)
@@ -57,6 +112,16 @@ See "go-farmer help <command>" for information on a specific command.
 			startCmd.Usage()
 		case "new":
 			newAccountCmd.Usage()
+		case "repair":
+			repairCmd.Usage()
+		case "mirrors":
+			mirrorsCmd.Usage()
+		case "contracts":
+			fmt.Print(contractsHelpMsg)
+		case "shards":
+			fmt.Print(shardsHelpMsg)
+		case "tokens":
+			fmt.Print(tokensHelpMsg)
 		default:
 			fmt.Print(helpMsg)
 			os.Exit(2)
@@ -85,6 +150,8 @@ func doCreateCfgfile(cNewConfigPath *string) {
   "local_addr": "local_public_ip:5003",
   "private_key": "%v",
   "data_dir": "/path/to/data",
+  "storage_allocation": "100GB",
+  "payment_address": "0x_your_wallet_address",
   "seed_list": [
     "genaro://renter_ip:4000/337472da3068fa05d415262baf4df5bada8aefdc"
   ],
@@ -100,6 +167,11 @@ func doCreateCfgfile(cNewConfigPath *string) {
 }
 
 func parseConfigFile(cPath *string) {
+	loadConfigFile(cPath, Cfg.Parse)
+}
+
+// load config file into Cfg and check it by parse, exit on error
+func loadConfigFile(cPath *string, parse func() error) {
 	logger := log.New("module", "cmd")
 	// check if config file exists
 	_, err := os.Stat(*cPath)
@@ -121,11 +193,71 @@ func parseConfigFile(cPath *string) {
 		logger.Crit("decode file error", "subject", "config", "error", err)
 		os.Exit(2)
 	}
-	if err := Cfg.Parse(); err != nil {
+	if err := parse(); err != nil {
 		logger.Crit("file bad format", "subject", "config", "error", err)
 		os.Exit(2)
 	}
-	js, _ := json.MarshalIndent(Cfg, "", "  ")
 	logger.Debug("successfully parsed", "subject", "config")
+}
+
+func printConfig() {
+	js, _ := json.MarshalIndent(Cfg, "", "  ")
 	fmt.Println(string(js))
 }
+
+func doRepair(action string, asJson bool) {
+	if err := OpenStorage(); err != nil {
+		fmt.Printf("open storage failed: %v\n", err)
+		os.Exit(2)
+	}
+	report, err := Repair(action)
+	BoltDB.Close()
+	if report == nil {
+		fmt.Printf("repair failed: %v\n", err)
+		os.Exit(2)
+	}
+
+	if asJson {
+		fmt.Println(JsonPrettyMarshal(report))
+	} else {
+		printList := func(title string, items []string) {
+			fmt.Printf("%v: %v\n", title, len(items))
+			for _, item := range items {
+				fmt.Printf("  %v\n", item)
+			}
+		}
+		printList("shards without contract", report.ShardsWithoutContract)
+		printList("contracts without shard", report.ContractsWithoutShard)
+		printList("contracts without trees", report.ContractsWithoutTrees)
+		printList("size mismatch", report.SizeMismatch)
+		printList("partial files", report.TmpFiles)
+		printList("repaired", report.Repaired)
+	}
+	if err != nil {
+		// repair stopped halfway
+		fmt.Printf("repair failed: %v\n", err)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func doListMirrors(cPath *string, asJson bool) {
+	openStorageForInspect(cPath)
+	jobs, err := listMirrorJobs()
+	BoltDB.Close()
+	if err != nil {
+		fmt.Printf("list mirror jobs failed: %v\n", err)
+		os.Exit(2)
+	}
+
+	if asJson {
+		fmt.Println(JsonPrettyMarshal(jobs))
+		os.Exit(0)
+	}
+	fmt.Printf("%-40v  %-8v  %-8v  %-19v  %v\n", "DATA_HASH", "STATE", "ATTEMPTS", "UPDATED", "ERROR")
+	for _, job := range jobs {
+		updated := time.Unix(job.Updated, 0).Format("2006-01-02 15:04:05")
+		fmt.Printf("%-40v  %-8v  %-8v  %-19v  %v\n", job.DataHash, job.State, job.Attempts, updated, job.Error)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/config"
+	"github.com/GenaroNetwork/go-farmer/msg"
+	"github.com/boltdb/bolt"
+)
+
+// read-only commands inspecting BoltDB and the shard store
+
+const contractsHelpMsg = `usage: go-farmer contracts <command> [<args>]
+Commands:
+	list  list contracts, filtered by renter, expiry and size
+	show  show a contract: go-farmer contracts show [-config path] [-json] <data_hash>
+`
+
+const shardsHelpMsg = `usage: go-farmer shards <command> [<args>]
+Commands:
+	verify  recompute data_hash of shards: go-farmer shards verify [-config path] [-json] <data_hash>|-all
+`
+
+const tokensHelpMsg = `usage: go-farmer tokens <command> [<args>]
+Commands:
+	list  list upload and download tokens
+`
+
+// open storage read-only without creating any dir, exit on error
+func openStorageForInspect(cPath *string) {
+	loadConfigFile(cPath, Cfg.ParseNoCreate)
+	err := OpenStorageReadOnly()
+	if err == ErrStorageLocked {
+		fmt.Println("open storage failed: BoltDB is in use by a running farmer, " +
+			"stop the farmer or inspect it by the admin API (admin_addr)")
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Printf("open storage failed: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+// parse flags before and after the first positional argument
+func parseWithArg(cmd *flag.FlagSet, args []string) string {
+	_ = cmd.Parse(args)
+	arg := cmd.Arg(0)
+	if cmd.NArg() > 1 {
+		_ = cmd.Parse(cmd.Args()[1:])
+	}
+	return arg
+}
+
+// call fn with every record of bucket, nothing if bucket does not exist
+func forEachRecord(bucket string, fn func(k, v []byte)) error {
+	return BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			fn(k, v)
+			return nil
+		})
+	})
+}
+
+func doContracts(args []string) {
+	if len(args) == 0 {
+		fmt.Print(contractsHelpMsg)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		doContractsList(args[1:])
+	case "show":
+		doContractsShow(args[1:])
+	default:
+		fmt.Print(contractsHelpMsg)
+		os.Exit(2)
+	}
+}
+
+func doContractsList(args []string) {
+	cmd := flag.NewFlagSet("contracts list", flag.ExitOnError)
+	cPath := cmd.String("config", "./config.json", "config file path")
+	renter := cmd.String("renter", "", "only contracts of renter node id")
+	expired := cmd.Bool("expired", false, "only contracts whose store_end has passed")
+	expiresWithin := cmd.Duration("expires-within", 0, "only contracts whose store_end is within duration, e.g. 72h")
+	minSize := cmd.String("min-size", "", "only contracts of data_size at least, e.g. 1MB")
+	maxSize := cmd.String("max-size", "", "only contracts of data_size at most, e.g. 1GB")
+	archived := cmd.Bool("archived", false, "list expired contracts archived by the reaper")
+	asJson := cmd.Bool("json", false, "print contracts as json")
+	_ = cmd.Parse(args)
+
+	var min, max int64
+	var err error
+	if *minSize != "" {
+		if min, err = config.ParseSize(*minSize); err != nil {
+			fmt.Printf("min-size invalid: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *maxSize != "" {
+		if max, err = config.ParseSize(*maxSize); err != nil {
+			fmt.Printf("max-size invalid: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	openStorageForInspect(cPath)
+	bucket := BucketContract
+	if *archived {
+		bucket = BucketArchive
+	}
+	// store_end is in milliseconds
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	within := now + int64(*expiresWithin/time.Millisecond)
+	infos := []contractInfo{}
+	err = forEachRecord(bucket, func(k, v []byte) {
+		var sItem storageItem
+		if err := json.Unmarshal(v, &sItem); err != nil {
+			return
+		}
+		c := sItem.Contract
+		storeEnd := int64(c.StoreEnd)
+		switch {
+		case *renter != "" && c.RenterID != *renter,
+			*expired && storeEnd >= now,
+			*expiresWithin > 0 && storeEnd > within,
+			min > 0 && int64(c.DataSize) < min,
+			max > 0 && int64(c.DataSize) > max:
+			return
+		}
+		infos = append(infos, newContractInfo(string(k), sItem))
+	})
+	BoltDB.Close()
+	if err != nil {
+		fmt.Printf("list contracts failed: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *asJson {
+		fmt.Println(JsonPrettyMarshal(infos))
+		os.Exit(0)
+	}
+	fmt.Printf("%-40v  %-40v  %-10v  %-19v  %-5v  %v\n", "DATA_HASH", "RENTER_ID", "SIZE", "STORE_END", "TREES", "SHARD")
+	for _, info := range infos {
+		shard := "missing"
+		if info.ShardSize >= 0 {
+			shard = humanizeSize(info.ShardSize)
+		}
+		fmt.Printf("%-40v  %-40v  %-10v  %-19v  %-5v  %v\n", info.DataHash, info.RenterID,
+			humanizeSize(info.DataSize), formatMillis(info.StoreEnd), info.Trees, shard)
+	}
+	os.Exit(0)
+}
+
+func formatMillis(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
+
+func doContractsShow(args []string) {
+	cmd := flag.NewFlagSet("contracts show", flag.ExitOnError)
+	cPath := cmd.String("config", "./config.json", "config file path")
+	asJson := cmd.Bool("json", false, "print contract as json")
+	dataHash := parseWithArg(cmd, args)
+	if dataHash == "" {
+		fmt.Print(contractsHelpMsg)
+		os.Exit(2)
+	}
+
+	openStorageForInspect(cPath)
+	var raw []byte
+	archived := false
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{BucketContract, BucketArchive} {
+			b := tx.Bucket([]byte(bucket))
+			if b == nil {
+				continue
+			}
+			if v := b.Get([]byte(dataHash)); v != nil {
+				raw = append([]byte{}, v...)
+				archived = bucket == BucketArchive
+				return nil
+			}
+		}
+		return nil
+	})
+	var sItem storageItem
+	if err == nil && raw == nil {
+		err = fmt.Errorf("contract of %v not found", dataHash)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &sItem)
+	}
+	tokens := 0
+	if err == nil {
+		err = forEachRecord(BucketToken, func(k, v []byte) {
+			var tItem tokenItem
+			if json.Unmarshal(v, &tItem) == nil && tItem.DataHash == dataHash {
+				tokens++
+			}
+		})
+	}
+	BoltDB.Close()
+	if err != nil {
+		fmt.Printf("show contract failed: %v\n", err)
+		os.Exit(2)
+	}
+
+	info := newContractInfo(dataHash, sItem)
+	if *asJson {
+		fmt.Println(JsonPrettyMarshal(struct {
+			contractInfo
+			Archived bool         `json:"archived"`
+			Tokens   int          `json:"tokens"`
+			Contract msg.Contract `json:"contract"`
+		}{info, archived, tokens, sItem.Contract}))
+		os.Exit(0)
+	}
+	shard := "missing"
+	if info.ShardSize >= 0 {
+		shard = fmt.Sprintf("%v (%v bytes)", humanizeSize(info.ShardSize), info.ShardSize)
+	}
+	fmt.Printf("data_hash:   %v\n", info.DataHash)
+	fmt.Printf("renter_id:   %v\n", info.RenterID)
+	fmt.Printf("data_size:   %v (%v bytes)\n", humanizeSize(info.DataSize), info.DataSize)
+	fmt.Printf("store_begin: %v\n", formatMillis(int64(sItem.Contract.StoreBegin)))
+	fmt.Printf("store_end:   %v\n", formatMillis(info.StoreEnd))
+	fmt.Printf("audit trees: %v\n", info.Trees)
+	fmt.Printf("shard:       %v\n", shard)
+	fmt.Printf("tokens:      %v\n", tokens)
+	fmt.Printf("archived:    %v\n", archived)
+	fmt.Printf("contract:\n%v\n", JsonPrettyMarshal(sItem.Contract))
+	os.Exit(0)
+}
+
+func doShards(args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Print(shardsHelpMsg)
+		os.Exit(2)
+	}
+	doShardsVerify(args[1:])
+}
+
+// result of verifying a shard
+type shardCheck struct {
+	DataHash string `json:"data_hash"`
+	Size     int64  `json:"size"`
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+func doShardsVerify(args []string) {
+	cmd := flag.NewFlagSet("shards verify", flag.ExitOnError)
+	cPath := cmd.String("config", "./config.json", "config file path")
+	all := cmd.Bool("all", false, "verify all the shards")
+	asJson := cmd.Bool("json", false, "print results as json")
+	dataHash := parseWithArg(cmd, args)
+	if (dataHash == "") == !*all {
+		fmt.Print(shardsHelpMsg)
+		os.Exit(2)
+	}
+
+	openStorageForInspect(cPath)
+	dataHashes := []string{dataHash}
+	if *all {
+		var err error
+		if dataHashes, err = Shards.List(); err != nil {
+			BoltDB.Close()
+			fmt.Printf("list shards failed: %v\n", err)
+			os.Exit(2)
+		}
+		sort.Strings(dataHashes)
+	}
+	checks := make([]shardCheck, 0, len(dataHashes))
+	failed := 0
+	for _, dataHash := range dataHashes {
+		check := verifyShard(dataHash)
+		if !check.Ok {
+			failed++
+		}
+		if !*asJson {
+			result := "ok"
+			if !check.Ok {
+				result = "FAILED: " + check.Error
+			}
+			fmt.Printf("%-40v  %-10v  %v\n", check.DataHash, humanizeSize(check.Size), result)
+		}
+		checks = append(checks, check)
+	}
+	BoltDB.Close()
+
+	if *asJson {
+		fmt.Println(JsonPrettyMarshal(checks))
+	} else {
+		fmt.Printf("%v shards verified, %v failed\n", len(checks), failed)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// recompute data_hash of shard, and check its size against the contract
+func verifyShard(dataHash string) shardCheck {
+	check := shardCheck{DataHash: dataHash}
+	size, err := Shards.Stat(dataHash)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Size = size
+	if sItem, err := getStorageItem(dataHash); err == nil {
+		size = int64(sItem.Contract.DataSize)
+	}
+	shard, err := Shards.Get(dataHash)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer shard.Close()
+	if _, err := io.Copy(ioutil.Discard, newShardVerifier(shard, dataHash, size)); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Ok = true
+	return check
+}
+
+func doTokens(args []string) {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Print(tokensHelpMsg)
+		os.Exit(2)
+	}
+	cmd := flag.NewFlagSet("tokens list", flag.ExitOnError)
+	cPath := cmd.String("config", "./config.json", "config file path")
+	dataHash := cmd.String("data-hash", "", "only tokens of data_hash")
+	asJson := cmd.Bool("json", false, "print tokens as json")
+	_ = cmd.Parse(args[1:])
+
+	openStorageForInspect(cPath)
+	type tokenInfo struct {
+		Token string `json:"token"`
+		tokenItem
+		Expired bool `json:"expired"`
+	}
+	now := time.Now()
+	tokens := []tokenInfo{}
+	err := forEachRecord(BucketToken, func(k, v []byte) {
+		var tItem tokenItem
+		if json.Unmarshal(v, &tItem) != nil {
+			return
+		}
+		if *dataHash != "" && tItem.DataHash != *dataHash {
+			return
+		}
+		tokens = append(tokens, tokenInfo{string(k), tItem, tItem.isExpired(now)})
+	})
+	BoltDB.Close()
+	if err != nil {
+		fmt.Printf("list tokens failed: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *asJson {
+		fmt.Println(JsonPrettyMarshal(tokens))
+		os.Exit(0)
+	}
+	fmt.Printf("%-32v  %-40v  %-8v  %-40v  %v\n", "TOKEN", "DATA_HASH", "OP", "NODE_ID", "EXPIRE")
+	for _, t := range tokens {
+		expire := time.Unix(t.Expire, 0).Format("2006-01-02 15:04:05")
+		if t.Expired {
+			expire += " (expired)"
+		}
+		fmt.Printf("%-32v  %-40v  %-8v  %-40v  %v\n", t.Token, t.DataHash, t.Operation, t.NodeID, expire)
+	}
+	os.Exit(0)
+}
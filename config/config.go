@@ -9,10 +9,19 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GenaroNetwork/go-farmer/msg"
 )
 
+const defaultNonceWindow = 300
+const defaultContractGracePeriod = "24h"
+const defaultMirrorConcurrency = 2
+const minAdminTokenLen = 16
+
+// payment address used before payment_address was configurable
+const defaultPaymentAddress = "0x5d14313c94f1b26d23f4ce3a49a2e136a88a584b"
+
 type Config struct {
 	LocalAddr  string   `json:"local_addr"`
 	PrivateKey string   `json:"private_key"`
@@ -20,10 +29,59 @@ type Config struct {
 	SeedList   []string `json:"seed_list"`
 	LogDir     string   `json:"log_dir"`
 	Protocol   string   `json:"protocol"`
+	// acceptable clock skew of message nonce, in seconds
+	NonceWindow int `json:"nonce_window"`
+	// shard storage backend, "flat" by default
+	ShardStore string `json:"shard_store"`
+	// max size of shards to store, e.g. "500GB". unlimited if empty
+	StorageAllocation string `json:"storage_allocation"`
+	// payment_destination of contracts we sign
+	PaymentAddress string        `json:"payment_address"`
+	ContractRules  ContractRules `json:"contract_rules"`
+	// time to keep shards after store_end, e.g. "24h"
+	ContractGracePeriod string `json:"contract_grace_period"`
+	// check storage consistency on start: "report", "quarantine" or "delete"
+	RepairOnStart string `json:"repair_on_start"`
+	// number of shards mirrored at the same time
+	MirrorConcurrency int `json:"mirror_concurrency"`
+	// max download rate of mirroring per second, e.g. "1MB". unlimited if empty
+	MirrorBandwidth string `json:"mirror_bandwidth"`
+	// max rate per second of shard data uploaded to us (CONSIGN and
+	// mirror) and downloaded from us (RETRIEVE), in total and per
+	// connection, e.g. "1MB". unlimited if empty
+	UploadRate          string `json:"upload_rate"`
+	DownloadRate        string `json:"download_rate"`
+	UploadRatePerConn   string `json:"upload_rate_per_conn"`
+	DownloadRatePerConn string `json:"download_rate_per_conn"`
+	// max number of shards uploaded to / downloaded from us
+	// at the same time, unlimited if 0
+	MaxUploads   int `json:"max_uploads"`
+	MaxDownloads int `json:"max_downloads"`
+	// serve https by certificate and key files, or by a self-signed
+	// certificate signed by node key generated if tls_auto is set.
+	// tls_cert should be issued by a public ca for the announced address
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	TLSAuto bool   `json:"tls_auto"`
+	// admin api listens on loopback address, e.g. "127.0.0.1:5004",
+	// disabled if empty. requests carry "Authorization: Bearer <admin_token>"
+	AdminAddr  string `json:"admin_addr"`
+	AdminToken string `json:"admin_token"`
+	// serve prometheus metrics on http://<metrics_addr>/metrics,
+	// e.g. ":9100". disabled if empty
+	MetricsAddr string `json:"metrics_addr"`
 
-	localIP   string
-	localPort uint16
-	seedList  []msg.Contact
+	localIP             string
+	localPort           uint16
+	seedList            []msg.Contact
+	nonceWindow         time.Duration
+	allocation          int64
+	gracePeriod         time.Duration
+	mirrorRate          int64
+	uploadRate          int64
+	downloadRate        int64
+	uploadRatePerConn   int64
+	downloadRatePerConn int64
 }
 
 func (c *Config) GetLocalPort() uint16 {
@@ -38,7 +96,18 @@ func (c *Config) GetLocalAddr() string {
 	return c.localIP
 }
 
+// validate config, and create shards, tmp and log dirs if not exist
 func (c *Config) Parse() error {
+	return c.parse(true)
+}
+
+// validate config without creating any dir, for commands
+// inspecting data_dir of a farmer
+func (c *Config) ParseNoCreate() error {
+	return c.parse(false)
+}
+
+func (c *Config) parse(createDirs bool) error {
 	// parse local addr
 	_, other, suc := splitScheme(c.LocalAddr)
 	if suc == false {
@@ -72,19 +141,27 @@ func (c *Config) Parse() error {
 	}
 	shardPath := path.Join(c.DataDir, "shards")
 	fInfo, err = os.Stat(shardPath)
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) && createDirs {
 		err := os.Mkdir(shardPath, 0700)
 		if err != nil {
 			return fmt.Errorf("create shards dir failed: %v", err)
 		}
 	}
+	tmpPath := path.Join(c.DataDir, "tmp")
+	fInfo, err = os.Stat(tmpPath)
+	if os.IsNotExist(err) && createDirs {
+		err := os.Mkdir(tmpPath, 0700)
+		if err != nil {
+			return fmt.Errorf("create tmp dir failed: %v", err)
+		}
+	}
 
 	// validate log file
 	if c.LogDir == "" {
 		c.LogDir = "."
 	}
 	fInfo, err = os.Stat(c.LogDir)
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) && createDirs {
 		if err := os.MkdirAll(c.LogDir, 0755); err != nil {
 			return err
 		}
@@ -95,6 +172,123 @@ func (c *Config) Parse() error {
 		return errors.New("protocol is empty")
 	}
 
+	// validate nonce window
+	if c.NonceWindow < 0 {
+		return errors.New("nonce_window is negative")
+	}
+	if c.NonceWindow == 0 {
+		c.NonceWindow = defaultNonceWindow
+	}
+	c.nonceWindow = time.Duration(c.NonceWindow) * time.Second
+
+	// validate storage allocation
+	if c.StorageAllocation != "" {
+		allocation, err := parseSize(c.StorageAllocation)
+		if err != nil {
+			return fmt.Errorf("storage_allocation invalid: %v", err)
+		}
+		c.allocation = allocation
+	}
+
+	// validate payment address
+	if c.PaymentAddress == "" {
+		c.PaymentAddress = defaultPaymentAddress
+	}
+	if !strings.HasPrefix(c.PaymentAddress, "0x") || len(c.PaymentAddress) != 42 ||
+		isValidHexStr(c.PaymentAddress[2:]) != nil {
+		return errors.New("payment_address invalid")
+	}
+
+	// validate contract rules
+	if err := c.ContractRules.Parse(); err != nil {
+		return fmt.Errorf("contract_rules invalid: %v", err)
+	}
+
+	// validate contract grace period
+	if c.ContractGracePeriod == "" {
+		c.ContractGracePeriod = defaultContractGracePeriod
+	}
+	gracePeriod, err := time.ParseDuration(c.ContractGracePeriod)
+	if err != nil || gracePeriod < 0 {
+		return errors.New("contract_grace_period invalid")
+	}
+	c.gracePeriod = gracePeriod
+
+	// validate repair on start
+	switch c.RepairOnStart {
+	case "", "report", "quarantine", "delete":
+	default:
+		return errors.New("repair_on_start should be report, quarantine or delete")
+	}
+
+	// validate mirror
+	if c.MirrorConcurrency < 0 {
+		return errors.New("mirror_concurrency is negative")
+	}
+	if c.MirrorConcurrency == 0 {
+		c.MirrorConcurrency = defaultMirrorConcurrency
+	}
+	if c.MirrorBandwidth != "" {
+		rate, err := parseSize(c.MirrorBandwidth)
+		if err != nil {
+			return fmt.Errorf("mirror_bandwidth invalid: %v", err)
+		}
+		c.mirrorRate = rate
+	}
+
+	// validate rate limits
+	rates := []struct {
+		name  string
+		value string
+		rate  *int64
+	}{
+		{"upload_rate", c.UploadRate, &c.uploadRate},
+		{"download_rate", c.DownloadRate, &c.downloadRate},
+		{"upload_rate_per_conn", c.UploadRatePerConn, &c.uploadRatePerConn},
+		{"download_rate_per_conn", c.DownloadRatePerConn, &c.downloadRatePerConn},
+	}
+	for _, r := range rates {
+		if r.value == "" {
+			continue
+		}
+		rate, err := parseSize(r.value)
+		if err != nil {
+			return fmt.Errorf("%v invalid: %v", r.name, err)
+		}
+		*r.rate = rate
+	}
+
+	// validate transfer limits
+	if c.MaxUploads < 0 || c.MaxDownloads < 0 {
+		return errors.New("max_uploads or max_downloads is negative")
+	}
+
+	// validate tls
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tls_cert and tls_key should be set together")
+	}
+
+	// validate admin api
+	if c.AdminAddr != "" {
+		ip, _, err := parseAddr(c.AdminAddr)
+		if err != nil {
+			return fmt.Errorf("admin_addr invalid: %v", err)
+		}
+		if !ip.IsLoopback() {
+			return errors.New("admin_addr should be a loopback address")
+		}
+		if len(c.AdminToken) < minAdminTokenLen {
+			return fmt.Errorf("admin_token should be at least %v characters", minAdminTokenLen)
+		}
+	}
+
+	// validate metrics
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return fmt.Errorf("metrics_addr invalid: %v", err)
+		}
+	}
+
 	// validate seed list
 	if c.SeedList == nil {
 		return errors.New("seed_list is empty")
@@ -102,7 +296,7 @@ func (c *Config) Parse() error {
 	c.seedList = make([]msg.Contact, 0)
 	for _, seed := range c.SeedList {
 		seed = strings.TrimSpace(seed)
-		_, addr, _ := splitScheme(seed)
+		scheme, addr, _ := splitScheme(seed)
 		if addr[len(addr)-1] == '/' {
 			addr = addr[:len(addr)-1]
 		}
@@ -128,6 +322,9 @@ func (c *Config) Parse() error {
 			Port:    port,
 			NodeID:  seps[1],
 		}
+		if scheme == "https://" {
+			contact.Protocol = msg.TLSProtocolSuffix
+		}
 		c.seedList = append(c.seedList, contact)
 	}
 	return nil
@@ -137,6 +334,63 @@ func (c *Config) GetSeedList() []msg.Contact {
 	return c.seedList
 }
 
+func (c *Config) GetNonceWindow() time.Duration {
+	return c.nonceWindow
+}
+
+// max size of shards to store, 0 if unlimited
+func (c *Config) GetStorageAllocation() int64 {
+	return c.allocation
+}
+
+func (c *Config) GetContractGracePeriod() time.Duration {
+	return c.gracePeriod
+}
+
+// status of farmer is saved here in daemon mode
+func (c *Config) GetStatusPath() string {
+	return path.Join(c.DataDir, "status.json")
+}
+
+// orphan shards are moved here by repair
+func (c *Config) GetQuarantinePath() string {
+	return path.Join(c.DataDir, "quarantine")
+}
+
+// bytes per second, 0 if unlimited
+func (c *Config) GetMirrorBandwidth() int64 {
+	return c.mirrorRate
+}
+
+// bytes per second, 0 if unlimited
+func (c *Config) GetUploadRate() int64 {
+	return c.uploadRate
+}
+
+// bytes per second, 0 if unlimited
+func (c *Config) GetDownloadRate() int64 {
+	return c.downloadRate
+}
+
+// bytes per second, 0 if unlimited
+func (c *Config) GetUploadRatePerConn() int64 {
+	return c.uploadRatePerConn
+}
+
+// bytes per second, 0 if unlimited
+func (c *Config) GetDownloadRatePerConn() int64 {
+	return c.downloadRatePerConn
+}
+
+func (c *Config) IsTLSEnabled() bool {
+	return c.TLSCert != "" || c.TLSAuto
+}
+
+// self-signed certificate is saved here
+func (c *Config) GetTLSPath() string {
+	return path.Join(c.DataDir, "tls")
+}
+
 func (c *Config) GetContractDBPath() string {
 	return path.Join(c.DataDir, "contract.db")
 }
@@ -145,6 +399,11 @@ func (c *Config) GetShardsPath() string {
 	return path.Join(c.DataDir, "shards")
 }
 
+// partial shards are written here before moved into shards path
+func (c *Config) GetTmpPath() string {
+	return path.Join(c.DataDir, "tmp")
+}
+
 /************* functions ***************/
 
 // http://127.0.0.1:8080 => (http://, 127.0.0.1:8080)
@@ -181,6 +440,39 @@ func parseAddr(addr string) (net.IP, uint16, error) {
 	return ip, uint16(port), nil
 }
 
+// ParseSize parses size like "500GB" into bytes
+func ParseSize(size string) (int64, error) {
+	return parseSize(size)
+}
+
+// 500GB => 500 * 1024^3
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(size, u.suffix) {
+			size = strings.TrimSpace(size[:len(size)-len(u.suffix)])
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("size bad format")
+	}
+	return int64(n * float64(mul)), nil
+}
+
 func isValidHexStr(str string) error {
 	if _, err := hex.DecodeString(str); err != nil {
 		return err
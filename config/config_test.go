@@ -0,0 +1,79 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100B", 100},
+		{"1KB", 1 << 10},
+		{"1.5kb", 1536},
+		{" 2 MB ", 2 << 20},
+		{"500GB", 500 << 30},
+		{"1TB", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil {
+			t.Errorf("parseSize(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "GB", "-1GB", "1PB", "one"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q): expected error", in)
+		}
+	}
+}
+
+func TestParseAdminAddr(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "go-farmer-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	const token = "0123456789abcdef"
+	tests := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"", "", true},
+		{"127.0.0.1:5004", token, true},
+		{"127.0.0.2:5004", token, true},
+		{"0.0.0.0:5004", token, false},
+		{"192.168.1.10:5004", token, false},
+		{"localhost:5004", token, false},
+		{"127.0.0.1", token, false},
+		{"127.0.0.1:5004", "", false},
+		{"127.0.0.1:5004", token[:minAdminTokenLen-1], false},
+	}
+	for _, tt := range tests {
+		c := Config{
+			LocalAddr:  "127.0.0.1:4000",
+			PrivateKey: "e8b6a4ab9b5b7b9d5f0e2c1a3f4d6b8e0c2a4f6d8b0e2c4a6f8d0b2e4c6a8f0d",
+			DataDir:    dataDir,
+			LogDir:     dataDir,
+			Protocol:   "1.2.0",
+			SeedList:   []string{},
+			AdminAddr:  tt.addr,
+			AdminToken: tt.token,
+		}
+		if err := c.Parse(); (err == nil) != tt.ok {
+			t.Errorf("admin_addr %q, admin_token %q: error %v, want ok %v", tt.addr, tt.token, err, tt.ok)
+		}
+	}
+}
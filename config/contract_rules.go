@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rules to accept contracts published by renters
+type ContractRules struct {
+	MinStoragePrice  int      `json:"min_storage_price"`
+	MinDownloadPrice int      `json:"min_download_price"`
+	MinDataSize      string   `json:"min_data_size"`
+	MaxDataSize      string   `json:"max_data_size"`
+	MinDuration      string   `json:"min_duration"` // e.g. "24h"
+	MaxDuration      string   `json:"max_duration"`
+	RenterAllowList  []string `json:"renter_allow_list"`
+	RenterDenyList   []string `json:"renter_deny_list"`
+
+	minDataSize int64
+	maxDataSize int64
+	minDuration time.Duration
+	maxDuration time.Duration
+	allow       map[string]bool
+	deny        map[string]bool
+}
+
+func (r *ContractRules) Parse() error {
+	var err error
+	if r.MinStoragePrice < 0 || r.MinDownloadPrice < 0 {
+		return errors.New("price is negative")
+	}
+
+	// data size
+	if r.MinDataSize != "" {
+		if r.minDataSize, err = parseSize(r.MinDataSize); err != nil {
+			return fmt.Errorf("min_data_size invalid: %v", err)
+		}
+	}
+	if r.MaxDataSize != "" {
+		if r.maxDataSize, err = parseSize(r.MaxDataSize); err != nil {
+			return fmt.Errorf("max_data_size invalid: %v", err)
+		}
+	}
+	if r.maxDataSize > 0 && r.minDataSize > r.maxDataSize {
+		return errors.New("min_data_size > max_data_size")
+	}
+
+	// duration
+	if r.MinDuration != "" {
+		if r.minDuration, err = time.ParseDuration(r.MinDuration); err != nil {
+			return fmt.Errorf("min_duration invalid: %v", err)
+		}
+	}
+	if r.MaxDuration != "" {
+		if r.maxDuration, err = time.ParseDuration(r.MaxDuration); err != nil {
+			return fmt.Errorf("max_duration invalid: %v", err)
+		}
+	}
+	if r.maxDuration > 0 && r.minDuration > r.maxDuration {
+		return errors.New("min_duration > max_duration")
+	}
+
+	// renters
+	r.allow = make(map[string]bool)
+	for _, id := range r.RenterAllowList {
+		if err := isValidHexStr(id); err != nil {
+			return fmt.Errorf("renter_allow_list invalid: %v", err)
+		}
+		r.allow[strings.ToLower(id)] = true
+	}
+	r.deny = make(map[string]bool)
+	for _, id := range r.RenterDenyList {
+		if err := isValidHexStr(id); err != nil {
+			return fmt.Errorf("renter_deny_list invalid: %v", err)
+		}
+		r.deny[strings.ToLower(id)] = true
+	}
+	return nil
+}
+
+// 0 if no limit
+func (r *ContractRules) GetMinDataSize() int64 {
+	return r.minDataSize
+}
+
+// 0 if no limit
+func (r *ContractRules) GetMaxDataSize() int64 {
+	return r.maxDataSize
+}
+
+// 0 if no limit
+func (r *ContractRules) GetMinDuration() time.Duration {
+	return r.minDuration
+}
+
+// 0 if no limit
+func (r *ContractRules) GetMaxDuration() time.Duration {
+	return r.maxDuration
+}
+
+// if renter is allowed by allow and deny list
+func (r *ContractRules) IsRenterAllowed(renterId string) bool {
+	renterId = strings.ToLower(renterId)
+	if r.deny[renterId] {
+		return false
+	}
+	if len(r.allow) != 0 && !r.allow[renterId] {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/config"
+	"github.com/GenaroNetwork/go-farmer/msg"
+	log "github.com/inconshreveable/log15"
+)
+
+// ContractPolicy decides which published contracts to offer for,
+// by the contract_rules of config
+type ContractPolicy struct {
+	rules          config.ContractRules
+	paymentAddress string
+	logger         log.Logger
+}
+
+func NewContractPolicy(cfg config.Config) *ContractPolicy {
+	return &ContractPolicy{
+		rules:          cfg.ContractRules,
+		paymentAddress: cfg.PaymentAddress,
+		logger:         log.New("module", "policy"),
+	}
+}
+
+// address to receive payment of contracts we sign
+func (p *ContractPolicy) PaymentAddress() string {
+	return p.paymentAddress
+}
+
+// if we accept the contract, the decision is logged with its reason
+func (p *ContractPolicy) Accept(c msg.Contract) bool {
+	if err := p.check(c); err != nil {
+		p.logger.Info("contract declined", "subject", "contract", "data_hash", c.DataHash, "renter", c.RenterID, "reason", err)
+		return false
+	}
+	p.logger.Info("contract accepted", "subject", "contract", "data_hash", c.DataHash, "renter", c.RenterID)
+	return true
+}
+
+func (p *ContractPolicy) check(c msg.Contract) error {
+	r := &p.rules
+
+	// renter
+	if !r.IsRenterAllowed(c.RenterID) {
+		return errors.New("renter not allowed")
+	}
+
+	// price
+	if c.PaymentStoragePrice < r.MinStoragePrice {
+		return fmt.Errorf("payment_storage_price %v < %v", c.PaymentStoragePrice, r.MinStoragePrice)
+	}
+	if c.PaymentDownloadPrice < r.MinDownloadPrice {
+		return fmt.Errorf("payment_download_price %v < %v", c.PaymentDownloadPrice, r.MinDownloadPrice)
+	}
+
+	// size
+	size := int64(c.DataSize)
+	if min := r.GetMinDataSize(); size < min {
+		return fmt.Errorf("data_size %v < %v", size, min)
+	}
+	if max := r.GetMaxDataSize(); max > 0 && size > max {
+		return fmt.Errorf("data_size %v > %v", size, max)
+	}
+
+	// duration, store_begin and store_end are in milliseconds
+	dur := time.Duration(c.StoreEnd-c.StoreBegin) * time.Millisecond
+	if min := r.GetMinDuration(); dur < min {
+		return fmt.Errorf("duration %v < %v", dur, min)
+	}
+	if max := r.GetMaxDuration(); max > 0 && dur > max {
+		return fmt.Errorf("duration %v > %v", dur, max)
+	}
+	return nil
+}
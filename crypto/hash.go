@@ -13,3 +13,9 @@ func Ripemd160Sha256(data []byte) []byte {
 	ret := hrip.Sum(nil)
 	return ret
 }
+
+func Ripemd160(data []byte) []byte {
+	hrip := ripemd160.New()
+	hrip.Write(data)
+	return hrip.Sum(nil)
+}
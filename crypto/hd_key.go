@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// first hardened child index of BIP32
+const hdHardened = 0x80000000
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range []byte(s) {
+		i := -1
+		for j := 0; j < len(base58Alphabet); j++ {
+			if base58Alphabet[j] == c {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	b := n.Bytes()
+	// leading '1' are leading zero bytes
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), b...), nil
+}
+
+// parse BIP32 extended public key (xpub), returns public key and chain code
+func parseExtendedPubkey(hdKey string) (x, y *big.Int, chainCode []byte, err error) {
+	b, err := base58Decode(hdKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// version(4) depth(1) fingerprint(4) child number(4) chain code(32) key(33) checksum(4)
+	if len(b) != 82 {
+		return nil, nil, nil, errors.New("incorrect hd key length")
+	}
+	payload, checksum := b[:78], b[78:]
+	h := sha256.Sum256(payload)
+	h = sha256.Sum256(h[:])
+	if !hmac.Equal(h[:4], checksum) {
+		return nil, nil, nil, errors.New("incorrect hd key checksum")
+	}
+	key := payload[45:]
+	if key[0] != 2 && key[0] != 3 {
+		return nil, nil, nil, errors.New("hd key is not public key")
+	}
+	x, y = secp256k1.DecompressPubkey(key)
+	if x == nil {
+		return nil, nil, nil, errors.New("invalid hd public key")
+	}
+	return x, y, payload[13:45], nil
+}
+
+// public key of the non-hardened child at index of extended public key hdKey
+func DeriveChildPubkey(hdKey string, index uint32) (x, y *big.Int, err error) {
+	if index >= hdHardened {
+		return nil, nil, errors.New("cannot derive hardened child from public key")
+	}
+	px, py, chainCode, err := parseExtendedPubkey(hdKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// I = HMAC-SHA512(chain code, serP(K) || ser32(index))
+	data := make([]byte, 37)
+	copy(data, CompressPubkey(px, py))
+	binary.BigEndian.PutUint32(data[33:], index)
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write(data)
+	i := mac.Sum(nil)
+
+	// child = point(IL) + K
+	curve := secp256k1.S256()
+	il := new(big.Int).SetBytes(i[:32])
+	if il.Cmp(curve.Params().N) >= 0 {
+		return nil, nil, errors.New("invalid child index")
+	}
+	ix, iy := curve.ScalarBaseMult(i[:32])
+	x, y = curve.Add(ix, iy, px, py)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, nil, errors.New("invalid child index")
+	}
+	return x, y, nil
+}
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// public derivation steps of BIP32 test vectors 1 and 2
+var deriveChildTests = []struct {
+	parent string
+	index  uint32
+	child  string // compressed public key
+}{
+	{ // m/0H -> m/0H/1
+		"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+		1,
+		"03501e454bf00751f24b1b489aa925215d66af2234e3891c3b21a52bedb3cd711c",
+	},
+	{ // m/0H/1/2H/2 -> m/0H/1/2H/2/1000000000
+		"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+		1000000000,
+		"022a471424da5e657499d1ff51cb43c47481a03b1e77f951fe64cec9f5a48f7011",
+	},
+}
+
+func TestDeriveChildPubkey(t *testing.T) {
+	for _, tt := range deriveChildTests {
+		x, y, err := DeriveChildPubkey(tt.parent, tt.index)
+		if err != nil {
+			t.Errorf("DeriveChildPubkey(%v, %v) error: %v", tt.parent, tt.index, err)
+			continue
+		}
+		if got := hex.EncodeToString(CompressPubkey(x, y)); got != tt.child {
+			t.Errorf("DeriveChildPubkey(%v, %v) = %v, want %v", tt.parent, tt.index, got, tt.child)
+		}
+	}
+}
+
+func TestDeriveChildPubkeyErrors(t *testing.T) {
+	parent := deriveChildTests[0].parent
+	if _, _, err := DeriveChildPubkey(parent, hdHardened); err == nil {
+		t.Error("hardened index: expected error")
+	}
+	// last character changed, checksum mismatch
+	bad := parent[:len(parent)-1] + "x"
+	if _, _, err := DeriveChildPubkey(bad, 0); err == nil {
+		t.Error("bad checksum: expected error")
+	}
+	if _, _, err := DeriveChildPubkey("0OIl", 0); err == nil {
+		t.Error("invalid base58: expected error")
+	}
+	if _, _, err := DeriveChildPubkey(parent[:50], 0); err == nil {
+		t.Error("short key: expected error")
+	}
+}
@@ -1,7 +1,6 @@
 package crypto
 
 import (
-	"encoding/hex"
 	"errors"
 	"math/big"
 
@@ -30,15 +29,7 @@ func (pk *PrivateKey) SetKey(keyHexStr string) error {
 	if len(xs) != 32 {
 		return errors.New("incorrect public key")
 	}
-	ret0 := uint8(3)
-	if y.Bit(0) == 0 {
-		ret0 = 2
-	}
-	nodeId := make([]byte, 33)
-	nodeId[0] = ret0
-	copy(nodeId[1:], xs)
-	nodeId = Ripemd160Sha256(nodeId)
-	pk.nodeId = hex.EncodeToString(nodeId)
+	pk.nodeId = NodeIdFromPubkey(x, y)
 
 	return nil
 }
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestSignRecoverNodeId(t *testing.T) {
+	var pk PrivateKey
+	if err := pk.SetKey("e8b6a4ab9b5b7b9d5f0e2c1a3f4d6b8e0c2a4f6d8b0e2c4a6f8d0b2e4c6a8f0d"); err != nil {
+		t.Fatal(err)
+	}
+	if len(pk.NodeId()) != 40 {
+		t.Fatalf("node id %q is not 160-bit hex", pk.NodeId())
+	}
+	hash := sha256.Sum256([]byte("message"))
+	sig := pk.Sign(hash[:])
+	if len(sig) != 65 {
+		t.Fatalf("signature length %v, want 65", len(sig))
+	}
+	nodeId, err := RecoverNodeId(hash[:], sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeId != pk.NodeId() {
+		t.Errorf("recovered node id %v, want %v", nodeId, pk.NodeId())
+	}
+
+	// another message recovers another key
+	other := sha256.Sum256([]byte("other message"))
+	if nodeId, err := RecoverNodeId(other[:], sig); err == nil && nodeId == pk.NodeId() {
+		t.Error("signature recovered node id of another message")
+	}
+	if _, err := RecoverNodeId(hash[:], sig[:64]); err == nil {
+		t.Error("short signature: expected error")
+	}
+}
+
+func TestSetKeyInvalid(t *testing.T) {
+	var pk PrivateKey
+	if err := pk.SetKey("not hex"); err == nil {
+		t.Error("expected error")
+	}
+}
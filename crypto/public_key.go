@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+)
+
+// compressed public key: 0x02/0x03 prefix followed by x
+func CompressPubkey(x, y *big.Int) []byte {
+	ret0 := uint8(3)
+	if y.Bit(0) == 0 {
+		ret0 = 2
+	}
+	pub := make([]byte, 33)
+	pub[0] = ret0
+	copy(pub[1:], math.PaddedBigBytes(x, 32))
+	return pub
+}
+
+// node id is RIPEMD160(SHA256(compressed public key))
+func NodeIdFromPubkey(x, y *big.Int) string {
+	return hex.EncodeToString(Ripemd160Sha256(CompressPubkey(x, y)))
+}
+
+// recover public key from a compact signature made by PrivateKey.Sign
+func RecoverPubkey(hash []byte, sig []byte) (x, y *big.Int, err error) {
+	if len(sig) != 65 {
+		return nil, nil, errors.New("incorrect signature length")
+	}
+	if sig[0] < 27 || sig[0] > 34 {
+		return nil, nil, errors.New("incorrect signature recovery flag")
+	}
+	recId := sig[0] - 27
+	if recId >= 4 {
+		// compressed
+		recId -= 4
+	}
+	s := make([]byte, 65)
+	copy(s, sig[1:])
+	s[64] = recId
+	pub, err := secp256k1.RecoverPubkey(hash, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pub) != 65 {
+		return nil, nil, errors.New("incorrect public key")
+	}
+	x = new(big.Int).SetBytes(pub[1:33])
+	y = new(big.Int).SetBytes(pub[33:])
+	return x, y, nil
+}
+
+// recover node id from a compact signature made by PrivateKey.Sign
+func RecoverNodeId(hash []byte, sig []byte) (string, error) {
+	x, y, err := RecoverPubkey(hash, sig)
+	if err != nil {
+		return "", err
+	}
+	return NodeIdFromPubkey(x, y), nil
+}
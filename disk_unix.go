@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// free bytes available to us on the filesystem of path
+func diskFree(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
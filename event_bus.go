@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Event published on the event bus, one of the Event* types
+type Event interface {
+	// name of the event type, e.g. "shard_stored"
+	EventName() string
+}
+
+// connectivity of heartbeat changed
+type EventNetState struct {
+	State NetState `json:"state"`
+}
+
+// contract offered to renter, in reply to PUBLISH
+type EventContractOffered struct {
+	DataHash string `json:"data_hash"`
+	RenterID string `json:"renter_id"`
+	DataSize int64  `json:"data_size"`
+}
+
+// contract signed by renter and saved
+type EventContractSigned struct {
+	DataHash string `json:"data_hash"`
+	RenterID string `json:"renter_id"`
+	DataSize int64  `json:"data_size"`
+}
+
+// shard uploaded or mirrored into the shard store
+type EventShardStored struct {
+	DataHash string `json:"data_hash"`
+	Size     int64  `json:"size"`
+}
+
+// shard deleted from the shard store
+type EventShardDeleted struct {
+	DataHash string `json:"data_hash"`
+	Size     int64  `json:"size"`
+}
+
+// AUDIT answered, with a proof if passed
+type EventAudit struct {
+	DataHash string `json:"data_hash"`
+	Passed   bool   `json:"passed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// state of a mirror job changed
+type EventMirror struct {
+	DataHash string `json:"data_hash"`
+	State    string `json:"state"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// a line logged
+type EventLog struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Text  string    `json:"text"`
+}
+
+func (EventNetState) EventName() string        { return "net_state" }
+func (EventContractOffered) EventName() string { return "contract_offered" }
+func (EventContractSigned) EventName() string  { return "contract_signed" }
+func (EventShardStored) EventName() string     { return "shard_stored" }
+func (EventShardDeleted) EventName() string    { return "shard_deleted" }
+func (EventAudit) EventName() string           { return "audit" }
+func (EventMirror) EventName() string          { return "mirror" }
+func (EventLog) EventName() string             { return "log" }
+
+// publish records of level info and above as EventLog
+func EventLogHandler() log.Handler {
+	return log.LvlFilterHandler(log.LvlInfo, log.FuncHandler(func(r *log.Record) error {
+		text := r.Msg
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			text += fmt.Sprintf(" %v=%v", r.Ctx[i], r.Ctx[i+1])
+		}
+		Events.Publish(EventLog{
+			Time:  r.Time,
+			Level: strings.ToUpper(r.Lvl.String()),
+			Text:  text,
+		})
+		return nil
+	}))
+}
+
+// EventBus delivers events to every subscriber. publishing never
+// blocks, events are dropped for subscribers whose buffer is full.
+type EventBus struct {
+	dropped uint64 // first for 64-bit alignment of atomic access
+	lock    sync.RWMutex
+	subs    map[chan Event]struct{}
+}
+
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// subscribe with a buffer of size events, call unsubscribe when done
+func (b *EventBus) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	b.lock.Lock()
+	b.subs[ch] = struct{}{}
+	b.lock.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subs, ch)
+			close(ch)
+			b.lock.Unlock()
+		})
+	}
+}
+
+// subscribe and call fn with every event in a new goroutine,
+// until ctx is done
+func (b *EventBus) Handle(ctx context.Context, size int, fn func(Event)) {
+	events, unsubscribe := b.Subscribe(size)
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-events:
+				fn(e)
+			}
+		}
+	}()
+}
+
+func (b *EventBus) Publish(e Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+}
+
+// number of events dropped for subscribers whose buffer was full
+func (b *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
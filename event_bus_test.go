@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBusPublish(t *testing.T) {
+	bus := NewEventBus()
+	slow, unsubscribeSlow := bus.Subscribe(1)
+	defer unsubscribeSlow()
+	fast, unsubscribeFast := bus.Subscribe(3)
+	defer unsubscribeFast()
+
+	// slow subscriber is full after the first event
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			bus.Publish(EventShardStored{DataHash: "hash", Size: int64(i)})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+	if got := bus.Dropped(); got != 2 {
+		t.Errorf("dropped %v events, want 2", got)
+	}
+
+	if e := (<-slow).(EventShardStored); e.Size != 0 {
+		t.Errorf("slow subscriber got event %v, want the first", e.Size)
+	}
+	for i := 0; i < 3; i++ {
+		if e := (<-fast).(EventShardStored); e.Size != int64(i) {
+			t.Errorf("fast subscriber got event %v, want %v", e.Size, i)
+		}
+	}
+
+	// no more drops when there's room again
+	bus.Publish(EventShardStored{DataHash: "hash", Size: 3})
+	if got := bus.Dropped(); got != 2 {
+		t.Errorf("dropped %v events after subscribers caught up, want 2", got)
+	}
+
+	// unsubscribed channels are not published to
+	unsubscribeSlow()
+	unsubscribeFast()
+	bus.Publish(EventShardStored{DataHash: "hash", Size: 4})
+	if got := bus.Dropped(); got != 2 {
+		t.Errorf("dropped %v events without subscribers, want 2", got)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -8,8 +9,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path"
 	"strconv"
 	"sync"
 	"time"
@@ -36,6 +35,16 @@ type storageItem struct {
 	Trees []string `json:"trees"`
 }
 
+func getStorageItem(dataHash string) (storageItem, error) {
+	var sItem storageItem
+	sItemRaw, err := BoltDbGet([]byte(dataHash), BucketContract)
+	if err != nil {
+		return sItem, err
+	}
+	err = json.Unmarshal(sItemRaw, &sItem)
+	return sItem, err
+}
+
 func init() {
 	contractCache = cache.New(2*time.Minute, 5*time.Minute)
 	mirrorCache = cache.New(2*time.Minute, 5*time.Minute)
@@ -44,21 +53,50 @@ func init() {
 type Farmer struct {
 	contact msg.Contact
 	pk      crypto.PrivateKey
+	router  *RoutingTable
+	policy  *ContractPolicy
+	mirrors *MirrorQueue
+
+	// cancelled on shutdown
+	ctx    context.Context
+	offers sync.WaitGroup
+
+	contactLock sync.RWMutex
+	// heartbeat
+	netState int32
+	natm     nat.Interface
+	natStop  chan struct{}
+	natDone  chan struct{}
 }
 
-func (f *Farmer) Init(config config.Config) error {
+func (f *Farmer) Init(ctx context.Context, config config.Config) error {
+	f.ctx = ctx
 	if err := f.pk.SetKey(Cfg.PrivateKey); err != nil {
 		return err
 	}
 	nodeId := f.pk.NodeId()
+	protocol := Cfg.Protocol
+	if Cfg.IsTLSEnabled() {
+		protocol += msg.TLSProtocolSuffix
+	}
 	f.SetContact(msg.Contact{
 		Address:  Cfg.GetLocalAddr(),
 		Port:     Cfg.GetLocalPort(),
 		NodeID:   nodeId,
-		Protocol: Cfg.Protocol,
+		Protocol: protocol,
 	})
+	f.router = NewRoutingTable(nodeId, f.ping)
+	f.policy = NewContractPolicy(Cfg)
+	if err := loadStorageTotals(f); err != nil {
+		return err
+	}
+	f.mirrors = NewMirrorQueue(Cfg.MirrorConcurrency, Cfg.GetMirrorBandwidth())
+	if err := f.mirrors.Start(ctx); err != nil {
+		return err
+	}
 
-	ChanSize <- f.getContractSize()
+	// remember messages until their nonce is out of window
+	replayCache = newReplayFilter(2 * Cfg.GetNonceWindow())
 
 	logger = log.New("module", "farmer")
 	return nil
@@ -81,13 +119,36 @@ func (f *Farmer) getContractSize() int64 {
 	return size
 }
 
+// wait for offers and mirrors in flight, after ctx of Init is done.
+// offers are cancelled, mirrors are resumed after restart.
+func (f *Farmer) Shutdown() {
+	f.offers.Wait()
+	f.mirrors.Wait()
+}
+
+// number of contacts in routing table
+func (f *Farmer) Peers() int {
+	return f.router.Size()
+}
+
 func (f *Farmer) Contact() msg.Contact {
+	f.contactLock.RLock()
+	defer f.contactLock.RUnlock()
 	return f.contact
 }
 func (f *Farmer) SetContact(contact msg.Contact) {
+	f.contactLock.Lock()
+	defer f.contactLock.Unlock()
 	f.contact = contact
 }
 
+// update address of contact, e.g. external ip of port mapping
+func (f *Farmer) setAddress(address string) {
+	f.contactLock.Lock()
+	defer f.contactLock.Unlock()
+	f.contact.Address = address
+}
+
 func (f *Farmer) PrivateKey() crypto.PrivateKey {
 	return f.pk
 }
@@ -115,6 +176,52 @@ func (f *Farmer) Sign(m IMessage) {
 	m.SetSignature(sigStr)
 }
 
+// check that the message is signed by the node in its contact
+func (f *Farmer) Verify(m IRequest) error {
+	sig, err := base64.StdEncoding.DecodeString(m.GetSignature())
+	if err != nil {
+		return errors.New("signature is not base64 string")
+	}
+	ms := m.GetId() + strconv.Itoa(m.GetNonce())
+	msHash := MagicHash([]byte(ms))
+	nodeId, err := crypto.RecoverNodeId(msHash[:], sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if nodeId != m.GetContact().NodeID {
+		return errors.New("signature does not match contact")
+	}
+	return nil
+}
+
+// reject stale messages and messages already received
+func (f *Farmer) checkReplay(m IRequest) error {
+	window := Cfg.GetNonceWindow()
+	skew := time.Since(nonceTime(m.GetNonce()))
+	if skew > window || skew < -window {
+		return errors.New("nonce out of window")
+	}
+
+	sender := m.GetContact().NodeID
+	return replayCache.Add(sender, sender+"/"+m.GetId()+"/"+strconv.Itoa(m.GetNonce()))
+}
+
+// nonce is unix time in seconds, milliseconds, microseconds
+// or nanoseconds (see Farmer.Sign)
+func nonceTime(nonce int) time.Time {
+	n := int64(nonce)
+	switch {
+	case n >= 1e17:
+		return time.Unix(0, n)
+	case n >= 1e14:
+		return time.Unix(0, n*int64(time.Microsecond))
+	case n >= 1e11:
+		return time.Unix(0, n*int64(time.Millisecond))
+	default:
+		return time.Unix(n, 0)
+	}
+}
+
 func (f *Farmer) SignContract(c *msg.Contract) {
 	nodeID := f.Contact().NodeID
 	c.FarmerID = &nodeID
@@ -127,12 +234,31 @@ func (f *Farmer) SignContract(c *msg.Contract) {
 }
 
 func (f *Farmer) ProcessMsgInOut(m *MsgInOut) {
+	start := time.Now()
 	m.ParseMsgInRaw()
 	msgStruct := m.MsgInStruct()
 
 	// switch message
 	var idStr string
 	var res IMessage
+
+	// verify sender
+	if req, ok := msgStruct.(IRequest); ok {
+		if err := f.Verify(req); err != nil {
+			logger.Warn("verify message failed", "subject", "message", "peer", req.GetContact().NodeID, "error", err)
+			idStr = req.GetId()
+			res = msg.NewResErr(f.Contact(), err.Error())
+			goto ret
+		}
+		if err := f.checkReplay(req); err != nil {
+			logger.Warn("reject message", "subject", "message", "peer", req.GetContact().NodeID, "id", req.GetId(), "error", err)
+			idStr = req.GetId()
+			res = msg.NewResErr(f.Contact(), err.Error())
+			goto ret
+		}
+		f.router.Update(req.GetContact())
+	}
+
 	switch msgStruct.(type) {
 	case *msg.Ping:
 		res = f.onPing(m)
@@ -188,43 +314,8 @@ ret:
 	res.SetId(idStr) // response id should be same as request id
 	f.Sign(res)
 	m.SetMsgOutStruct(res)
-}
-
-func (f *Farmer) HeartBeat() {
-	// try join network
-	joinSucc := f.doJoinNetwork()
-
-	if joinSucc == false {
-		// port-forwarding
-		logger.Warn("try upnp/pmp port-forwarding", "subject", "heartbeat")
-		natm := nat.Any()
-		ip, err := natm.ExternalIP()
-		if err != nil {
-			logger.Warn("get external ip failed", "subject", "heartbeat")
-		}
-		f.contact.Address = ip.String()
-		go f._map(natm, nil, "TCP", int(Cfg.GetLocalPort()), int(Cfg.GetLocalPort()), "Genaro Sharer")
-
-		// try join network
-		joinSucc := f.doJoinNetwork()
-		if joinSucc == false {
-			logger.Warn("join network by port-forwarding failed", "subject", "heartbeat")
-		}
-	}
-	logger.Info("join network success", "subject", "heartbeat")
-
-	// probe periodically
-	isDisconnected := false
-	for {
-		time.Sleep(time.Second * 10)
-		if joinSucc := f.doJoinNetwork(); joinSucc == false {
-			isDisconnected = true
-			log.Warn("disconnected from network", "subject", "heartbeat")
-		} else if isDisconnected {
-			isDisconnected = false
-			log.Info("reconnected", "subject", "heartbeat")
-		}
-	}
+	recordActivity(m, res)
+	observeRpc(m, res, start)
 }
 
 func (f *Farmer) doJoinNetwork() (joinSucc bool) {
@@ -290,7 +381,7 @@ func (f *Farmer) ping(contact msg.Contact) error {
 	f.Sign(&msgPing)
 	msgInOut := MsgInOut{}
 	msgInOut.SetMsgOutStruct(&msgPing)
-	err := SendMsg(contact, &msgInOut, time.Second*4, func() error {
+	err := SendMsgContext(f.ctx, contact, &msgInOut, time.Second*4, func() error {
 		msgInOut.ParseMsgInRaw()
 		return nil
 	})
@@ -314,7 +405,7 @@ func (f *Farmer) probe(contact msg.Contact) error {
 	f.Sign(&msgProbe)
 	msgInOut := MsgInOut{}
 	msgInOut.SetMsgOutStruct(&msgProbe)
-	err := SendMsg(contact, &msgInOut, time.Second*8, func() error {
+	err := SendMsgContext(f.ctx, contact, &msgInOut, time.Second*8, func() error {
 		msgInOut.ParseMsgInRaw()
 		return nil
 	})
@@ -326,28 +417,132 @@ func (f *Farmer) probe(contact msg.Contact) error {
 	return err
 }
 
-func (f *Farmer) findNode(contact msg.Contact) {
+func (f *Farmer) findNode(contact msg.Contact, key string) ([]msg.Contact, error) {
 	logger := logger.New("subject", "find_node")
 	msgFindNode := msg.FindNode{
 		JsonRpc: "2.0",
 		Method:  msg.MFindNode,
 		Params: msg.FindNodeParams{
-			Key:     f.Contact().NodeID,
+			Key:     key,
 			Contact: f.Contact(),
 		},
 	}
 	f.Sign(&msgFindNode)
 	msgInOut := MsgInOut{}
 	msgInOut.SetMsgOutStruct(&msgFindNode)
-	err := SendMsg(contact, &msgInOut, time.Second*4, func() error {
-		msgInOut.ParseMsgInRaw()
-		return nil
+	var nodes []msg.Contact
+	err := SendMsgContext(f.ctx, contact, &msgInOut, time.Second*4, func() error {
+		msgInOut.ParseResInRaw(msg.MFindNode)
+		msgInStruct := msgInOut.MsgInStruct()
+		switch msgInStruct.(type) {
+		case *msg.ResErr:
+			msgResErr := msgInStruct.(*msg.ResErr)
+			return errors.New(msgResErr.Error.Message)
+		case *msg.FindNodeRes:
+			nodes = msgInStruct.(*msg.FindNodeRes).Result.Nodes
+			return nil
+		default:
+			return fmt.Errorf("unknown response %v", string(msgInOut.MsgInRaw()))
+		}
 	})
 	if err != nil {
 		logger.Warn("find_node failed", "peer", contact.NodeID, "error", err)
 	} else {
-		logger.Info("find_node success", "peer", contact.NodeID)
+		logger.Info("find_node success", "peer", contact.NodeID, "nodes", len(nodes))
+	}
+	return nodes, err
+}
+
+// iterative lookup of the contacts closest to key
+func (f *Farmer) lookup(key string, start []msg.Contact) []msg.Contact {
+	kid := decodeNodeId(key)
+	if kid == nil {
+		return nil
+	}
+	self := f.Contact().NodeID
+
+	shortlist := f.router.Nearest(key, kBucketSize, self)
+	seen := make(map[string]bool)
+	for _, c := range shortlist {
+		seen[c.NodeID] = true
+	}
+	for _, c := range start {
+		if decodeNodeId(c.NodeID) != nil && !seen[c.NodeID] && c.NodeID != self {
+			seen[c.NodeID] = true
+			shortlist = append(shortlist, c)
+		}
+	}
+	sortByDistance(shortlist, kid)
+
+	contacted := make(map[string]bool)
+	for {
+		// ALPHA closest contacts not yet queried
+		var round []msg.Contact
+		for _, c := range shortlist {
+			if !contacted[c.NodeID] {
+				round = append(round, c)
+				if len(round) == kAlpha {
+					break
+				}
+			}
+		}
+		if len(round) == 0 {
+			break
+		}
+
+		type result struct {
+			contact msg.Contact
+			nodes   []msg.Contact
+			err     error
+		}
+		results := make(chan result, len(round))
+		for _, c := range round {
+			contacted[c.NodeID] = true
+			go func(c msg.Contact) {
+				nodes, err := f.findNode(c, key)
+				results <- result{c, nodes, err}
+			}(c)
+		}
+
+		failed := make(map[string]bool)
+		for range round {
+			r := <-results
+			if r.err != nil {
+				failed[r.contact.NodeID] = true
+				f.router.Remove(r.contact.NodeID)
+				continue
+			}
+			f.router.Update(r.contact)
+			for _, c := range r.nodes {
+				if decodeNodeId(c.NodeID) == nil || seen[c.NodeID] || c.NodeID == self {
+					continue
+				}
+				seen[c.NodeID] = true
+				shortlist = append(shortlist, c)
+			}
+		}
+
+		// drop unresponsive contacts, keep K closest
+		alive := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c.NodeID] {
+				alive = append(alive, c)
+			}
+		}
+		shortlist = alive
+		sortByDistance(shortlist, kid)
+		if len(shortlist) > kBucketSize {
+			shortlist = shortlist[:kBucketSize]
+		}
 	}
+	return shortlist
+}
+
+// lookup our own node id to populate routing table
+func (f *Farmer) refreshRoutingTable() {
+	logger := logger.New("subject", "routing table")
+	nodes := f.lookup(f.Contact().NodeID, Cfg.GetSeedList())
+	logger.Info("refreshed", "closest", len(nodes), "size", f.router.Size())
 }
 
 func (f *Farmer) offer(contact msg.Contact, c msg.Contract) error {
@@ -363,7 +558,7 @@ func (f *Farmer) offer(contact msg.Contact, c msg.Contract) error {
 	f.Sign(&msgOffer)
 	msgInOut := MsgInOut{}
 	msgInOut.SetMsgOutStruct(&msgOffer)
-	err := SendMsg(contact, &msgInOut, time.Second*4, func() error {
+	err := SendMsgContext(f.ctx, contact, &msgInOut, time.Second*4, func() error {
 		msgInOut.ParseResInRaw(msg.MOffer)
 		msgInStruct := msgInOut.MsgInStruct()
 		switch msgInStruct.(type) {
@@ -375,6 +570,18 @@ func (f *Farmer) offer(contact msg.Contact, c msg.Contract) error {
 			msgOfferRes := msgInStruct.(*msg.OfferRes)
 			contract := msgOfferRes.Result.Contract
 
+			// check if it's the contract we offered, signed by renter.
+			// the renter only adds renter_signature, any other change
+			// would be signed by the renter but not agreed by us
+			if contract.Stringify() != c.Stringify() ||
+				contract.FarmerSignature == nil || c.FarmerSignature == nil ||
+				*contract.FarmerSignature != *c.FarmerSignature {
+				return errors.New("contract does not match offer")
+			}
+			if err := verifyRenterSignature(contract); err != nil {
+				return err
+			}
+
 			// check if audit_count is power of 2
 			if contract.AuditCount == 0 || (contract.AuditCount&(contract.AuditCount-1)) != 0 {
 				return errors.New("audit_count is not power of 2")
@@ -389,6 +596,13 @@ func (f *Farmer) offer(contact msg.Contact, c msg.Contract) error {
 			if err != nil {
 				return err
 			}
+			addCommittedBytes(int64(contract.DataSize))
+			contractEvents.Inc("signed")
+			Events.Publish(EventContractSigned{
+				DataHash: contract.DataHash,
+				RenterID: contract.RenterID,
+				DataSize: int64(contract.DataSize),
+			})
 			return nil
 		default:
 			return fmt.Errorf("unknown response %v", string(msgInOut.MsgInRaw()))
@@ -465,6 +679,12 @@ func (f *Farmer) onPublish(m *MsgInOut) IMessage {
 	}
 	logger.Info("", "uuid", _uuid, "data_hash", _dataHash)
 
+	// if contract signed by renter
+	if err := verifyRenterSignature(contract); err != nil {
+		logger.Warn("verify contract failed", "uuid", _uuid, "data_hash", _dataHash, "renter", contract.RenterID, "error", err)
+		return f._generalRes(m)
+	}
+
 	// if already processed
 	// check data_hash
 	_, okDataHash := contractCache.Get(_dataHash)
@@ -473,21 +693,42 @@ func (f *Farmer) onPublish(m *MsgInOut) IMessage {
 		doOffer = false
 	}
 
-	// TODO: send offer (or not) according to contract
+	// if we accept the contract
+	if doOffer && f.policy.Accept(contract) == false {
+		doOffer = false
+	}
+
+	// if we have space for the shard
+	if doOffer {
+		if err := f.reserveOffer(_dataHash, int64(contract.DataSize)); err != nil {
+			logger.Info("decline publish", "uuid", _uuid, "data_hash", _dataHash, "data_size", contract.DataSize, "reason", err)
+			doOffer = false
+		}
+	}
+
 	if doOffer {
 		contractCache.Set(_dataHash, nil, cache.DefaultExpiration)
-		addr := "0x5d14313c94f1b26d23f4ce3a49a2e136a88a584b"
+		addr := f.policy.PaymentAddress()
 		contract.PaymentDestination = &addr
 		f.SignContract(&contract)
+		f.offers.Add(1)
 		go func() {
+			defer f.offers.Done()
 			c := make(chan struct{})
 			offerLock.Store(_dataHash, c)
+			contractEvents.Inc("offered")
+			Events.Publish(EventContractOffered{
+				DataHash: contract.DataHash,
+				RenterID: contract.RenterID,
+				DataSize: int64(contract.DataSize),
+			})
 			if err := f.offer(msgPublish.Params.Contact, contract); err != nil {
 				// delete cache so we can process it again
 				contractCache.Delete(_dataHash)
 			}
-			c <- struct{}{}
+			releaseOffer(_dataHash)
 			offerLock.Delete(_dataHash)
+			close(c)
 		}()
 	}
 	return f._generalRes(m)
@@ -496,17 +737,18 @@ func (f *Farmer) onPublish(m *MsgInOut) IMessage {
 func (f *Farmer) onFindNode(m *MsgInOut) IMessage {
 	logger := logger.New("subject", "on find_node")
 
+	msgFindNode := m.MsgInStruct().(*msg.FindNode)
+	peer := msgFindNode.Params.Contact
+	nodes := f.router.Nearest(msgFindNode.Params.Key, kBucketSize, peer.NodeID)
+
 	c := f.Contact()
 	res := msg.FindNodeRes{
 		Result: msg.FindNodeResResult{
-			Nodes:   []msg.Contact{},
+			Nodes:   nodes,
 			Contact: c,
 		},
 	}
-
-	msgFindNode := m.MsgInStruct().(*msg.FindNode)
-	peer := msgFindNode.Params.Contact
-	logger.Info("on find_node", "peer", peer.NodeID)
+	logger.Info("on find_node", "peer", peer.NodeID, "nodes", len(nodes))
 	return &res
 }
 
@@ -574,6 +816,12 @@ func (f *Farmer) onConsign(m *MsgInOut) IMessage {
 		}
 	}
 
+	// only the renter of the contract may upload
+	if sItem.Contract.RenterID != msgConsign.Params.Contact.NodeID {
+		logger.Warn("consign from non-renter", "data_hash", dataHash, "peer", msgConsign.Params.Contact.NodeID)
+		return msg.NewResErr(f.Contact(), "not renter of contract")
+	}
+
 	// verify trees length
 	if sItem.Contract.AuditCount != len(trees) {
 		logger.Warn("AuditCount incorrect", "data_hash", dataHash)
@@ -590,9 +838,14 @@ func (f *Farmer) onConsign(m *MsgInOut) IMessage {
 		}
 	}
 
+	// if we can serve the upload
+	if !uploadSlots.Available() {
+		logger.Warn("too many uploads", "data_hash", dataHash)
+		return msg.NewResErr(f.Contact(), "too many uploads")
+	}
+
 	// generate and save token
-	token := hex.EncodeToString(uuid.NewV4().Bytes())
-	err = BoltDbSet([]byte(token), []byte{}, BucketToken, false)
+	token, err := newToken(dataHash, TokenUpload, msgConsign.Params.Contact.NodeID)
 	if err != nil {
 		logger.Warn("save token error", "data_hash", dataHash, "error", err)
 		return &msg.ResErr{
@@ -644,23 +897,43 @@ func (f *Farmer) onConsign(m *MsgInOut) IMessage {
 func (f *Farmer) onRetrieve(m *MsgInOut) IMessage {
 	logger := logger.New("subject", "on retrieve")
 
-	// TODO: shard exist?
 	msgRetrieve := m.MsgInStruct().(*msg.Retrieve)
 	dataHash := msgRetrieve.Params.DataHash
+
+	// only the renter of the contract may download
+	sItem, err := getStorageItem(dataHash)
+	if err != nil {
+		logger.Warn("no contract for data_hash", "data_hash", dataHash)
+		return msg.NewResErr(f.Contact(), "no contract for data_hash")
+	}
+	if sItem.Contract.RenterID != msgRetrieve.Params.Contact.NodeID {
+		logger.Warn("retrieve from non-renter", "data_hash", dataHash, "peer", msgRetrieve.Params.Contact.NodeID)
+		return msg.NewResErr(f.Contact(), "not renter of contract")
+	}
+
+	// shard exist
+	if _, err := Shards.Stat(dataHash); err != nil {
+		logger.Warn("no shard", "data_hash", dataHash, "error", err)
+		return msg.NewResErr(f.Contact(), "no shard")
+	}
+
+	if !downloadSlots.Available() {
+		logger.Warn("too many downloads", "data_hash", dataHash)
+		return msg.NewResErr(f.Contact(), "too many downloads")
+	}
+	token, err := newToken(dataHash, TokenDownload, msgRetrieve.Params.Contact.NodeID)
+	if err != nil {
+		logger.Warn("save token error", "data_hash", dataHash, "error", err)
+		return msg.NewResErr(f.Contact(), "internal error")
+	}
+	logger.Info("saved token", "data_hash", dataHash, "token", token)
 	c := f.Contact()
-	token := hex.EncodeToString(uuid.NewV4().Bytes())
 	res := msg.RetrieveRes{
 		Result: msg.RetrieveResResult{
 			Token:   token,
 			Contact: c,
 		},
 	}
-	err := BoltDbSet([]byte(token), []byte{}, BucketToken, false)
-	if err != nil {
-		logger.Warn("save token error", "data_hash", dataHash, "error", err)
-	} else {
-		logger.Info("saved token", "data_hash", dataHash, "token", token)
-	}
 	return &res
 }
 
@@ -668,14 +941,7 @@ func (f *Farmer) onMirror(m *MsgInOut) IMessage {
 	logger := logger.New("subject", "on mirror")
 
 	msgMirror := m.MsgInStruct().(*msg.Mirror)
-	// if already processed
 	dataHash := msgMirror.Params.DataHash
-	_, okDataHash := mirrorCache.Get(dataHash)
-	if okDataHash {
-		logger.Warn("message already processed", "data_hash", dataHash)
-		return f._generalRes(m)
-	}
-	mirrorCache.Set(dataHash, nil, time.Second*30)
 
 	// if contract exist
 	sItemRaw, err := BoltDbGet([]byte(dataHash), BucketContract)
@@ -683,14 +949,29 @@ func (f *Farmer) onMirror(m *MsgInOut) IMessage {
 		logger.Info("no signed contract", "data_hash", dataHash, "error", err)
 		return msg.NewResErr(f.Contact(), "no signed contract")
 	}
-
-	// if trees exists
 	var sItem storageItem
 	err = json.Unmarshal(sItemRaw, &sItem)
 	if err != nil {
 		logger.Info("sItem bad format", "data_hash", dataHash, "error", err)
 		return msg.NewResErr(f.Contact(), "sItem bad format")
 	}
+
+	// only the renter of the contract may mirror, the job
+	// replaces the audit trees of the contract
+	if sItem.Contract.RenterID != msgMirror.Params.Contact.NodeID {
+		logger.Warn("mirror from non-renter", "data_hash", dataHash, "peer", msgMirror.Params.Contact.NodeID)
+		return msg.NewResErr(f.Contact(), "not renter of contract")
+	}
+
+	// if already processed
+	_, okDataHash := mirrorCache.Get(dataHash)
+	if okDataHash {
+		logger.Warn("message already processed", "data_hash", dataHash)
+		return f._generalRes(m)
+	}
+	mirrorCache.Set(dataHash, nil, time.Second*30)
+
+	// if trees exists
 	trees := msgMirror.Params.AuditTree
 	if sItem.Contract.AuditCount != len(trees) {
 		logger.Info("audit trees length != audit count", "data_hash", dataHash)
@@ -698,34 +979,35 @@ func (f *Farmer) onMirror(m *MsgInOut) IMessage {
 	}
 
 	// if shard exist
-	fPath := path.Join(Cfg.GetShardsPath(), dataHash)
-	_, err = os.Stat(fPath)
-	if os.IsExist(err) {
+	_, err = Shards.Stat(dataHash)
+	if err == nil {
 		logger.Warn("shard already exist", "data_hash", dataHash)
 		return f._generalRes(m)
 	}
 
-	// TODO: lock contract ?
-	logger.Info("mirroring shard", "data_hash", dataHash)
-	err = DownloadShard(msgMirror.Params.Farmer, dataHash, msgMirror.Params.Token)
-	if err != nil {
-		logger.Warn("download shard error", "data_hash", dataHash, "error", err)
-		return msg.NewResErr(f.Contact(), "mirror shard failed")
+	// queue mirror job, trees are saved after shard downloaded
+	size := int64(sItem.Contract.DataSize)
+	err = f.mirrors.Add(msgMirror.Params.Farmer, dataHash, msgMirror.Params.Token, trees, size)
+	if err == ErrMirrorJobExist {
+		logger.Warn("mirror job already exist", "data_hash", dataHash)
+		return f._generalRes(m)
 	}
-
-	// save trees
-	sItem.Trees = trees
-	sItemRaw, _ = json.Marshal(sItem)
-	err = BoltDbSet([]byte(dataHash), sItemRaw, BucketContract, true)
 	if err != nil {
-		// TODO: save trees failed, but have shard downloaded
-		logger.Warn("save audit trees error", "data_hash", dataHash, "error", err)
+		logger.Warn("add mirror job error", "data_hash", dataHash, "error", err)
 		return msg.NewResErr(f.Contact(), "internal error")
 	}
+	logger.Info("mirror job added", "data_hash", dataHash)
 	return f._generalRes(m)
 }
 
 func (f *Farmer) onAudit(m *MsgInOut) IMessage {
+	res := f.auditShard(m)
+	recordAudit(m.MsgInStruct().(*msg.Audit), res)
+	return res
+}
+
+// answer AUDIT with a proof of the challenged shard
+func (f *Farmer) auditShard(m *MsgInOut) IMessage {
 	logger := logger.New("subject", "on audit")
 
 	// check challenge existence
@@ -738,9 +1020,8 @@ func (f *Farmer) onAudit(m *MsgInOut) IMessage {
 	logger.Info("on audit", "data_hash", audit.DataHash)
 
 	// check shard existence
-	fPath := path.Join(Cfg.GetShardsPath(), audit.DataHash)
-	_, err := os.Stat(fPath)
-	if os.IsNotExist(err) {
+	_, err := Shards.Stat(audit.DataHash)
+	if err == ErrShardNotFound {
 		logger.Warn("no shard", "data_hash", audit.DataHash)
 		return &msg.ResErr{
 			Res: msg.Res{
@@ -793,7 +1074,7 @@ func (f *Farmer) onAudit(m *MsgInOut) IMessage {
 	}
 
 	// open shard for read
-	fHandle, err := os.Open(fPath)
+	shard, err := Shards.Get(audit.DataHash)
 	if err != nil {
 		logger.Warn("open shard error", "data_hash", audit.DataHash, "error", err)
 		return &msg.ResErr{
@@ -808,7 +1089,7 @@ func (f *Farmer) onAudit(m *MsgInOut) IMessage {
 			},
 		}
 	}
-	defer fHandle.Close()
+	defer shard.Close()
 
 	// sha256 of shard
 	h := sha256.New()
@@ -828,7 +1109,10 @@ func (f *Farmer) onAudit(m *MsgInOut) IMessage {
 		}
 	}
 	h.Write(chal)
-	if _, err := io.Copy(h, fHandle); err != nil {
+	hashStart := time.Now()
+	_, err = io.Copy(h, shard)
+	auditHashDuration.ObserveSince(hashStart)
+	if err != nil {
 		logger.Warn("read shard error", "data_hash", audit.DataHash, "error", err)
 		return &msg.ResErr{
 			Res: msg.Res{
@@ -910,3 +1194,35 @@ func (f *Farmer) onAudit(m *MsgInOut) IMessage {
 		},
 	}
 }
+
+// check that the contract is signed by its renter
+func verifyRenterSignature(c msg.Contract) error {
+	sig, err := base64.StdEncoding.DecodeString(c.RenterSignature)
+	if err != nil {
+		return errors.New("renter_signature is not base64 string")
+	}
+	hash := MagicHash([]byte(c.Stringify()))
+	x, y, err := crypto.RecoverPubkey(hash[:], sig)
+	if err != nil {
+		return fmt.Errorf("invalid renter_signature: %v", err)
+	}
+
+	// signed by the key derived from renter_hd_key at renter_hd_index
+	if c.RenterHDKey != "" {
+		if c.RenterHDIndex < 0 {
+			return errors.New("renter_hd_index is negative")
+		}
+		cx, cy, err := crypto.DeriveChildPubkey(c.RenterHDKey, uint32(c.RenterHDIndex))
+		if err != nil {
+			return fmt.Errorf("invalid renter_hd_key: %v", err)
+		}
+		if cx.Cmp(x) != 0 || cy.Cmp(y) != 0 {
+			return errors.New("renter_signature does not match renter_hd_key")
+		}
+	}
+
+	if crypto.NodeIdFromPubkey(x, y) != c.RenterID {
+		return errors.New("renter_signature does not match renter_id")
+	}
+	return nil
+}
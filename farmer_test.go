@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/crypto"
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+// contract signed by the renter key pk
+func signedTestContract(pk crypto.PrivateKey) msg.Contract {
+	c := msg.Contract{
+		RenterID: pk.NodeId(),
+		DataSize: 1024,
+		DataHash: "5d41402abc4b2a76b9719d911017c592c4a4d4e2",
+		StoreEnd: 1600000000000,
+	}
+	hash := MagicHash([]byte(c.Stringify()))
+	c.RenterSignature = base64.StdEncoding.EncodeToString(pk.Sign(hash[:]))
+	return c
+}
+
+func TestVerifyRenterSignature(t *testing.T) {
+	var pk, other crypto.PrivateKey
+	if err := pk.SetKey("e8b6a4ab9b5b7b9d5f0e2c1a3f4d6b8e0c2a4f6d8b0e2c4a6f8d0b2e4c6a8f0d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SetKey("1f0e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyRenterSignature(signedTestContract(pk)); err != nil {
+		t.Errorf("signed contract rejected: %v", err)
+	}
+	tests := []struct {
+		name   string
+		modify func(c *msg.Contract)
+	}{
+		{"field changed", func(c *msg.Contract) { c.DataSize++ }},
+		{"other renter_id", func(c *msg.Contract) { c.RenterID = other.NodeId() }},
+		{"not base64", func(c *msg.Contract) { c.RenterSignature = "!" }},
+		{"short signature", func(c *msg.Contract) { c.RenterSignature = c.RenterSignature[:20] }},
+	}
+	for _, tt := range tests {
+		c := signedTestContract(pk)
+		tt.modify(&c)
+		if err := verifyRenterSignature(c); err == nil {
+			t.Errorf("%v: contract accepted", tt.name)
+		}
+	}
+}
+
+func TestVerifyRenterSignatureHDKey(t *testing.T) {
+	// private key of m/0H/1 of BIP32 test vector 1
+	var pk crypto.PrivateKey
+	if err := pk.SetKey("3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"); err != nil {
+		t.Fatal(err)
+	}
+	// extended public key of m/0H
+	hdKey := "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+
+	sign := func(index int) msg.Contract {
+		c := msg.Contract{
+			RenterID:      pk.NodeId(),
+			RenterHDKey:   hdKey,
+			RenterHDIndex: index,
+			DataSize:      1024,
+			DataHash:      "5d41402abc4b2a76b9719d911017c592c4a4d4e2",
+		}
+		hash := MagicHash([]byte(c.Stringify()))
+		c.RenterSignature = base64.StdEncoding.EncodeToString(pk.Sign(hash[:]))
+		return c
+	}
+	if err := verifyRenterSignature(sign(1)); err != nil {
+		t.Errorf("contract signed by child key rejected: %v", err)
+	}
+	if err := verifyRenterSignature(sign(2)); err == nil {
+		t.Error("contract signed by key of another index accepted")
+	}
+	if err := verifyRenterSignature(sign(-1)); err == nil {
+		t.Error("negative renter_hd_index accepted")
+	}
+}
+
+func TestNonceTime(t *testing.T) {
+	now := time.Unix(1500000000, 123456789)
+	tests := []struct {
+		nonce int
+		want  time.Time
+	}{
+		{1500000000, time.Unix(1500000000, 0)},
+		{1500000000123, time.Unix(1500000000, 123000000)},
+		{1500000000123456, time.Unix(1500000000, 123456000)},
+		{1500000000123456789, now},
+	}
+	for _, tt := range tests {
+		if got := nonceTime(tt.nonce); !got.Equal(tt.want) {
+			t.Errorf("nonceTime(%v) = %v, want %v", tt.nonce, got, tt.want)
+		}
+	}
+}
+
+func TestCheckReplay(t *testing.T) {
+	defer setupTestStorage(t)()
+	replayCache = newReplayFilter(2 * Cfg.GetNonceWindow())
+	f := &Farmer{}
+	window := Cfg.GetNonceWindow()
+	ping := func(id string, at time.Time) *msg.Ping {
+		return &msg.Ping{
+			Id: id,
+			Params: msg.PingParams{
+				Contact: msg.Contact{NodeID: "0123456789abcdef0123456789abcdef01234567"},
+				Nonce:   int(at.UnixNano() / int64(time.Millisecond)),
+			},
+		}
+	}
+
+	now := time.Now()
+	if err := f.checkReplay(ping("1", now)); err != nil {
+		t.Errorf("fresh message rejected: %v", err)
+	}
+	if err := f.checkReplay(ping("1", now)); err == nil {
+		t.Error("replayed message accepted")
+	}
+	if err := f.checkReplay(ping("2", now.Add(-window/2))); err != nil {
+		t.Errorf("message in window rejected: %v", err)
+	}
+	if err := f.checkReplay(ping("3", now.Add(-window-time.Minute))); err == nil {
+		t.Error("stale message accepted")
+	}
+	if err := f.checkReplay(ping("4", now.Add(window+time.Minute))); err == nil {
+		t.Error("message from the future accepted")
+	}
+}
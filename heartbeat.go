@@ -0,0 +1,190 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/nat"
+)
+
+// connectivity of the farmer to the network
+type NetState int32
+
+const (
+	NetJoining      NetState = iota // not joined yet
+	NetConnected                    // seeds are reachable
+	NetDegraded                     // recent probes failed
+	NetDisconnected                 // probes keep failing
+)
+
+func (s NetState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s NetState) String() string {
+	switch s {
+	case NetJoining:
+		return "joining"
+	case NetConnected:
+		return "connected"
+	case NetDegraded:
+		return "degraded"
+	case NetDisconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	heartbeatInterval   = 10 * time.Second
+	heartbeatMaxBackoff = 5 * time.Minute
+	// failed probes in a row before degraded becomes disconnected
+	degradedThreshold = 3
+	// check external address of port mapping
+	natCheckInterval = 5 * time.Minute
+)
+
+func (f *Farmer) NetState() NetState {
+	return NetState(atomic.LoadInt32(&f.netState))
+}
+
+func (f *Farmer) setNetState(s NetState) {
+	old := NetState(atomic.SwapInt32(&f.netState, int32(s)))
+	if old == s {
+		return
+	}
+	if s == NetConnected {
+		logger.Info("state changed", "subject", "heartbeat", "from", old, "to", s)
+	} else {
+		logger.Warn("state changed", "subject", "heartbeat", "from", old, "to", s)
+	}
+	Events.Publish(EventNetState{State: s})
+}
+
+// probe seeds periodically, and move between joining, connected,
+// degraded and disconnected. retries back off exponentially while
+// not connected, and port mapping is set up again when disconnected.
+// port mapping is deleted when ctx of Init is done.
+func (f *Farmer) HeartBeat() {
+	logger := logger.New("subject", "heartbeat")
+	defer f.unmapPort()
+	failures := 0
+	lastNatCheck := time.Now()
+	for f.ctx.Err() == nil {
+		state := f.NetState()
+
+		// external address of port mapping may change
+		addrChanged := false
+		if f.natm != nil && time.Since(lastNatCheck) >= natCheckInterval {
+			lastNatCheck = time.Now()
+			addrChanged = f.checkExternalIP()
+		}
+
+		joinSucc := f.doJoinNetwork()
+		if f.ctx.Err() != nil {
+			return
+		}
+		if !joinSucc && (f.natm == nil || state == NetDisconnected) {
+			logger.Warn("try upnp/pmp port-forwarding")
+			if f.mapPort() {
+				lastNatCheck = time.Now()
+				joinSucc = f.doJoinNetwork()
+			}
+		}
+
+		if joinSucc {
+			failures = 0
+		} else {
+			failures++
+		}
+		f.setNetState(nextNetState(state, joinSucc, failures))
+		// announce ourselves to the closest nodes
+		if joinSucc && (state == NetJoining || state == NetDisconnected || addrChanged) {
+			go f.refreshRoutingTable()
+		}
+
+		select {
+		case <-f.ctx.Done():
+		case <-time.After(heartbeatBackoff(failures)):
+		}
+	}
+}
+
+// state after a probe, failures is the number of failed probes in a row
+func nextNetState(state NetState, joined bool, failures int) NetState {
+	switch {
+	case joined:
+		return NetConnected
+	case state == NetJoining:
+		return NetDisconnected
+	case state == NetConnected:
+		return NetDegraded
+	case state == NetDegraded && failures >= degradedThreshold:
+		return NetDisconnected
+	}
+	return state
+}
+
+// heartbeatInterval doubled by every failure in a row
+func heartbeatBackoff(failures int) time.Duration {
+	backoff := heartbeatInterval
+	for i := 1; i < failures && backoff < heartbeatMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > heartbeatMaxBackoff {
+		backoff = heartbeatMaxBackoff
+	}
+	return backoff
+}
+
+// discover upnp/pmp gateway, map local port and use its external ip.
+// port mapping set up before is removed.
+func (f *Farmer) mapPort() bool {
+	logger := logger.New("subject", "heartbeat")
+	f.unmapPort()
+	natm := nat.Any()
+	ip, err := natm.ExternalIP()
+	if err != nil {
+		logger.Warn("get external ip failed", "error", err)
+		return false
+	}
+	f.natm = natm
+	f.natStop = make(chan struct{})
+	f.natDone = make(chan struct{})
+	f.setAddress(ip.String())
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		f._map(natm, stop, "TCP", int(Cfg.GetLocalPort()), int(Cfg.GetLocalPort()), "Genaro Sharer")
+	}(f.natStop, f.natDone)
+	return true
+}
+
+// stop port mapping and wait for it to be deleted
+func (f *Farmer) unmapPort() {
+	if f.natStop == nil {
+		return
+	}
+	close(f.natStop)
+	<-f.natDone
+	f.natStop = nil
+	f.natDone = nil
+	f.natm = nil
+}
+
+// update contact if external ip changed, returns true if changed
+func (f *Farmer) checkExternalIP() bool {
+	logger := logger.New("subject", "heartbeat")
+	ip, err := f.natm.ExternalIP()
+	if err != nil {
+		logger.Warn("get external ip failed", "error", err)
+		return false
+	}
+	old := f.Contact().Address
+	if ip.String() == old {
+		return false
+	}
+	logger.Info("external ip changed", "from", old, "to", ip.String())
+	f.setAddress(ip.String())
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+func TestNextNetState(t *testing.T) {
+	tests := []struct {
+		state    NetState
+		joined   bool
+		failures int
+		want     NetState
+	}{
+		{NetJoining, true, 0, NetConnected},
+		{NetJoining, false, 1, NetDisconnected},
+		{NetConnected, true, 0, NetConnected},
+		{NetConnected, false, 1, NetDegraded},
+		{NetDegraded, false, degradedThreshold - 1, NetDegraded},
+		{NetDegraded, false, degradedThreshold, NetDisconnected},
+		{NetDegraded, true, 0, NetConnected},
+		{NetDisconnected, false, degradedThreshold + 1, NetDisconnected},
+		{NetDisconnected, true, 0, NetConnected},
+	}
+	for _, tt := range tests {
+		if got := nextNetState(tt.state, tt.joined, tt.failures); got != tt.want {
+			t.Errorf("%v, joined %v, %v failures: got %v, want %v", tt.state, tt.joined, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestSetNetState(t *testing.T) {
+	logger = log.New("module", "farmer")
+	logger.SetHandler(log.DiscardHandler())
+	events, unsubscribe := Events.Subscribe(10)
+	defer unsubscribe()
+	f := &Farmer{}
+	for _, s := range []NetState{NetConnected, NetConnected, NetDegraded, NetDegraded, NetConnected} {
+		f.setNetState(s)
+		if f.NetState() != s {
+			t.Errorf("state %v, want %v", f.NetState(), s)
+		}
+	}
+	// an event only when state changed
+	for _, want := range []NetState{NetConnected, NetDegraded, NetConnected} {
+		select {
+		case e := <-events:
+			if e, ok := e.(EventNetState); !ok || e.State != want {
+				t.Errorf("event %v, want %v", e, want)
+			}
+		default:
+			t.Fatalf("no event for %v", want)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e)
+	default:
+	}
+}
+
+func TestHeartbeatBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, heartbeatInterval},
+		{1, heartbeatInterval},
+		{2, 2 * heartbeatInterval},
+		{3, 4 * heartbeatInterval},
+		{5, 16 * heartbeatInterval},
+		{6, heartbeatMaxBackoff},
+		{100, heartbeatMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := heartbeatBackoff(tt.failures); got != tt.want {
+			t.Errorf("%v failures: backoff %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
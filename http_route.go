@@ -1,12 +1,11 @@
 package main
 
 import (
-	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"path"
 	"regexp"
+	"strconv"
+	"time"
 )
 
 type route struct {
@@ -60,6 +59,15 @@ func RootHandler(node INode) http.HandlerFunc {
 func ShardHandler() http.HandlerFunc {
 	logger := logger.New("subject", "shard handler")
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		w = sw
+		defer func() {
+			method := httpMethodLabel(r.Method)
+			shardRequests.Inc(method, strconv.Itoa(sw.code))
+			shardDuration.ObserveSince(start, method)
+		}()
+
 		dataHash := r.URL.Path[len("/shards/"):]
 
 		// get token from request
@@ -70,80 +78,115 @@ func ShardHandler() http.HandlerFunc {
 			return
 		}
 		// check if token is valid
-		_, err := BoltDbGet([]byte(token), BucketToken)
+		tItem, err := getToken(token)
 		if err != nil {
 			logger.Info("check token existence error", "data_hash", dataHash, "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		operation := TokenDownload
+		if r.Method == "POST" {
+			operation = TokenUpload
+		}
+		if err := tItem.check(dataHash, operation); err != nil {
+			logger.Info("token rejected", "data_hash", dataHash, "token", token, "renter", tItem.NodeID, "error", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 
 		// check if shard already exist
-		fPath := path.Join(Cfg.GetShardsPath(), dataHash)
-		_, err = os.Stat(fPath)
+		size, err := Shards.Stat(dataHash)
 
-		// download shard
-		if r.Method == "GET" {
-			logger := logger.New("method", "GET")
-			logger.Info("", "data_hash", dataHash, "token", token)
-			if os.IsNotExist(err) {
+		// download shard, or a range of it
+		if r.Method == "GET" || r.Method == "HEAD" {
+			logger := logger.New("method", r.Method)
+			logger.Info("", "data_hash", dataHash, "token", token, "range", r.Header.Get("Range"))
+			if err == ErrShardNotFound {
 				logger.Warn("no shard", "data_hash", dataHash, "token", token)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			fHandle, err := os.Open(fPath)
+			sItem, err := getStorageItem(dataHash)
 			if err != nil {
-				// TODO: close handle?
-				logger.Warn("open shard error", "data_hash", dataHash, "token", token, "error", err)
+				logger.Warn("get contract error", "data_hash", dataHash, "token", token, "error", err)
 				w.WriteHeader(http.StatusNotFound)
 				return
 			}
-			defer fHandle.Close()
-			_, err = io.Copy(w, fHandle)
+			if size != int64(sItem.Contract.DataSize) {
+				logger.Warn("shard size mismatch", "data_hash", dataHash, "token", token, "size", size, "data_size", sItem.Contract.DataSize)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if r.Method == "GET" {
+				if !downloadSlots.TryAcquire() {
+					logger.Warn("too many downloads", "data_hash", dataHash, "token", token)
+					w.Header().Set("Retry-After", transferRetryAfter)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				defer downloadSlots.Release()
+				defer trackTransfer(TokenDownload, dataHash, tItem.NodeID)()
+			}
+			shard, err := Shards.Get(dataHash)
 			if err != nil {
-				logger.Warn("copy shard error", "data_hash", dataHash, "token", token, "error", err)
+				logger.Warn("open shard error", "data_hash", dataHash, "token", token, "error", err)
+				w.WriteHeader(http.StatusNotFound)
 				return
 			}
-			logger.Info("GET shard success", "data_hash", dataHash, "token", token)
+			defer shard.Close()
+
+			// ServeContent handles HEAD, Range and If-Range/If-None-Match by ETag,
+			// Content-Length is data_size of contract or size of the range
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("ETag", `"`+dataHash+`"`)
+			w.Header().Set("X-Data-Hash", dataHash)
+			http.ServeContent(w, r, "", time.Time{}, newLimitedShardReader(shard, &downloadMeter, downloadLimiters()...))
+			logger.Info(r.Method+" shard success", "data_hash", dataHash, "token", token)
 			return
 		}
 		// upload shard
 		if r.Method == "POST" {
 			logger := logger.New("method", "POST")
-			if os.IsExist(err) {
+			if err == nil {
 				logger.Warn("shard already exist", "data_hash", dataHash, "token", token)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			// save shard
-			fHandle, err := os.Create(fPath)
+
+			// get contract
+			sItem, err := getStorageItem(dataHash)
 			if err != nil {
-				logger.Warn("create shard error", "data_hash", dataHash, "token", token, "error", err)
-				w.WriteHeader(http.StatusInternalServerError)
+				logger.Warn("get contract error", "data_hash", dataHash, "token", token, "error", err)
+				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			size, err := io.Copy(fHandle, r.Body)
+
+			if !uploadSlots.TryAcquire() {
+				logger.Warn("too many uploads", "data_hash", dataHash, "token", token)
+				w.Header().Set("Retry-After", transferRetryAfter)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			defer uploadSlots.Release()
+			defer trackTransfer(TokenUpload, dataHash, tItem.NodeID)()
+
+			// save shard, verified against contract while writing
+			body := newLimitedReader(r.Body, &uploadMeter, uploadLimiters()...)
+			body = newShardVerifier(body, dataHash, int64(sItem.Contract.DataSize))
+			size, err := Shards.Put(dataHash, body)
 			if err != nil {
-				// TODO: close handle ?
-				if err != nil {
-					logger.Warn("save shard error", "data_hash", dataHash, "token", token, "error", err)
-				}
-				// if exist, remove the broken file
-				_, err = os.Stat(fPath)
-				if os.IsExist(err) {
-					rErr := os.Remove(fPath)
-					if rErr != nil {
-						logger.Warn("remove broken file error", "data_hash", dataHash, "token", token, "error", err)
-					}
-				}
+				logger.Warn("save shard error", "data_hash", dataHash, "token", token, "error", err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			cErr := fHandle.Close()
-			if cErr != nil {
-				logger.Warn("close file error", "data_hash", dataHash, "token", token, "error", err)
-			}
 			logger.Info("POST shard success", "data_hash", dataHash, "token", token)
-			ChanSize <- size
+			// upload token is used up
+			if err := deleteToken(token); err != nil {
+				logger.Warn("delete token error", "data_hash", dataHash, "token", token, "error", err)
+			}
+			addStoredBytes(size)
+			observeShard("stored", size)
+			Events.Publish(EventShardStored{DataHash: dataHash, Size: size})
 		}
 	}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/crypto"
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+func TestShardHandlerRange(t *testing.T) {
+	defer setupTestStorage(t)()
+
+	content := []byte("0123456789abcdef")
+	dataHash := hex.EncodeToString(crypto.Ripemd160Sha256(content))
+	if _, err := Shards.Put(dataHash, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	js, _ := json.Marshal(storageItem{Contract: msg.Contract{DataHash: dataHash, DataSize: len(content)}})
+	if err := BoltDbSet([]byte(dataHash), js, BucketContract, false); err != nil {
+		t.Fatal(err)
+	}
+	token := "00112233445566778899aabbccddeeff"
+	js, _ = json.Marshal(tokenItem{
+		DataHash:  dataHash,
+		Operation: TokenDownload,
+		Expire:    time.Now().Add(time.Hour).Unix(),
+	})
+	if err := BoltDbSet([]byte(token), js, BucketToken, false); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := ShardHandler()
+	tests := []struct {
+		name         string
+		method       string
+		header       map[string]string
+		code         int
+		contentRange string
+		body         []byte
+	}{
+		{"whole", "GET", nil, http.StatusOK, "", content},
+		{"range", "GET", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "bytes 2-5/16", content[2:6]},
+		{"suffix range", "GET", map[string]string{"Range": "bytes=-4"}, http.StatusPartialContent, "bytes 12-15/16", content[12:]},
+		{"open range", "GET", map[string]string{"Range": "bytes=10-"}, http.StatusPartialContent, "bytes 10-15/16", content[10:]},
+		{"unsatisfiable range", "GET", map[string]string{"Range": "bytes=16-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */16", nil},
+		{"not modified", "GET", map[string]string{"If-None-Match": `"` + dataHash + `"`}, http.StatusNotModified, "", nil},
+		{"head", "HEAD", nil, http.StatusOK, "", nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/shards/"+dataHash+"?token="+token, nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%v: status %v, want %v", tt.name, w.Code, tt.code)
+			continue
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%v: Content-Range %q, want %q", tt.name, got, tt.contentRange)
+		}
+		if tt.method == "HEAD" && w.Header().Get("Content-Length") != "16" {
+			t.Errorf("%v: Content-Length %q, want data_size", tt.name, w.Header().Get("Content-Length"))
+		}
+		if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+			t.Errorf("%v: body %q, want %q", tt.name, w.Body.Bytes(), tt.body)
+		}
+	}
+
+	// token not issued
+	req := httptest.NewRequest("GET", "/shards/"+dataHash+"?token=unknown", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown token: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestShardHandlerTransferSlots(t *testing.T) {
+	defer setupTestStorage(t)()
+	uploadSlots = newTransferSlots(1)
+	downloadSlots = newTransferSlots(1)
+
+	// a stored shard to download, and a contract without shard to upload
+	stored := []byte("stored shard")
+	storedHash := hex.EncodeToString(crypto.Ripemd160Sha256(stored))
+	if _, err := Shards.Put(storedHash, bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+	upload := []byte("uploaded shard")
+	uploadHash := hex.EncodeToString(crypto.Ripemd160Sha256(upload))
+	for _, c := range []msg.Contract{
+		{DataHash: storedHash, DataSize: len(stored)},
+		{DataHash: uploadHash, DataSize: len(upload)},
+	} {
+		js, _ := json.Marshal(storageItem{Contract: c})
+		if err := BoltDbSet([]byte(c.DataHash), js, BucketContract, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putToken := func(token, dataHash, operation string) {
+		js, _ := json.Marshal(tokenItem{
+			DataHash:  dataHash,
+			Operation: operation,
+			Expire:    time.Now().Add(time.Hour).Unix(),
+		})
+		if err := BoltDbSet([]byte(token), js, BucketToken, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putToken("download", storedHash, TokenDownload)
+	putToken("upload", uploadHash, TokenUpload)
+
+	handler := ShardHandler()
+	do := func(method, dataHash, token string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/shards/"+dataHash+"?token="+token, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// all slots taken
+	tests := []struct {
+		name  string
+		slots *transferSlots
+		do    func() *httptest.ResponseRecorder
+	}{
+		{"download", downloadSlots, func() *httptest.ResponseRecorder { return do("GET", storedHash, "download", nil) }},
+		{"upload", uploadSlots, func() *httptest.ResponseRecorder { return do("POST", uploadHash, "upload", upload) }},
+	}
+	for _, tt := range tests {
+		if !tt.slots.TryAcquire() {
+			t.Fatalf("%v: no free slot", tt.name)
+		}
+		w := tt.do()
+		tt.slots.Release()
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%v: status %v, want %v", tt.name, w.Code, http.StatusServiceUnavailable)
+		}
+		if got := w.Header().Get("Retry-After"); got != transferRetryAfter {
+			t.Errorf("%v: Retry-After %q, want %q", tt.name, got, transferRetryAfter)
+		}
+	}
+
+	// slots are released when a transfer is done, failed or not
+	checkReleased := func(name string) {
+		if uploadSlots.Active() != 0 || downloadSlots.Active() != 0 {
+			t.Errorf("%v: %v uploads and %v downloads still active", name, uploadSlots.Active(), downloadSlots.Active())
+		}
+		if n := len(activeTransfers()); n != 0 {
+			t.Errorf("%v: %v transfers still tracked", name, n)
+		}
+	}
+	if w := do("GET", storedHash, "download", nil); w.Code != http.StatusOK {
+		t.Errorf("download: status %v, want %v", w.Code, http.StatusOK)
+	}
+	checkReleased("download")
+	if w := do("POST", uploadHash, "upload", []byte("not the shard!")); w.Code != http.StatusBadRequest {
+		t.Errorf("bad upload: status %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	checkReleased("bad upload")
+	if w := do("POST", uploadHash, "upload", upload); w.Code != http.StatusOK {
+		t.Errorf("upload: status %v, want %v", w.Code, http.StatusOK)
+	}
+	checkReleased("upload")
+}
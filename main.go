@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"path"
 	"regexp"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/GenaroNetwork/go-farmer/config"
@@ -16,54 +23,144 @@ import (
 const MagicBytes = "Bitcoin Signed Message:\n"
 const BucketContract = "CONTRACT"
 const BucketToken = "TOKEN"
+const BucketArchive = "ARCHIVE"
+const BucketMirror = "MIRROR"
 
 var BoltDB *bolt.DB
+var Shards ShardStore
 var Cfg config.Config
-var ChanSize = make(chan int64, 10)
+
+// BoltDB is held by another process, e.g. a running farmer
+var ErrStorageLocked = errors.New("boltdb is locked by another process")
+
+// max time to wait for requests in flight on shutdown
+const shutdownTimeout = time.Minute
+
+// open BoltDB and create buckets, then the shard store
+func OpenStorage() error {
+	return openStorage(false)
+}
+
+// open BoltDB read-only for inspection, buckets may not exist
+func OpenStorageReadOnly() error {
+	return openStorage(true)
+}
+
+func openStorage(readOnly bool) error {
+	// bolt creates a missing file even if read-only
+	if _, err := os.Stat(Cfg.GetContractDBPath()); readOnly && err != nil {
+		return fmt.Errorf("cannot open boltdb: %v", err)
+	}
+	boltDB, err := bolt.Open(Cfg.GetContractDBPath(), 0600, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return ErrStorageLocked
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open boltdb: %v", err)
+	}
+	BoltDB = boltDB
+	// read-only transactions cannot create buckets
+	if !readOnly {
+		err = BoltDB.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(BucketContract))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucketIfNotExists([]byte(BucketToken))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucketIfNotExists([]byte(BucketArchive))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucketIfNotExists([]byte(BucketMirror))
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			BoltDB.Close()
+			return fmt.Errorf("create boltdb bucket error: %v", err)
+		}
+	}
+
+	Shards, err = NewShardStore(Cfg)
+	if err != nil {
+		BoltDB.Close()
+		return fmt.Errorf("cannot open shard store: %v", err)
+	}
+	return nil
+}
 
 func main() {
 	ParseCmdArgs()
 
 	// setup logger
-	logFile := path.Join(Cfg.LogDir, "go-farmer.log")
-	logHandler, err := log.FileHandler(logFile, log.LogfmtFormat())
-	if err != nil {
-		log.Crit("setup logger failed", "ERROR", err)
-		return
+	if StartOpts.LogStdout {
+		log.Root().SetHandler(log.StreamHandler(os.Stdout, log.LogfmtFormat()))
+	} else {
+		logFile := path.Join(Cfg.LogDir, "go-farmer.log")
+		logHandler, err := log.FileHandler(logFile, log.LogfmtFormat())
+		if err != nil {
+			log.Crit("setup logger failed", "ERROR", err)
+			return
+		}
+		// log panel of terminal ui
+		log.Root().SetHandler(log.MultiHandler(logHandler, EventLogHandler()))
 	}
-	log.Root().SetHandler(logHandler)
 
-	// prepare boltdb
-	boltDB, err := bolt.Open(Cfg.GetContractDBPath(), 0600, nil)
-	BoltDB = boltDB
-	if err != nil {
-		log.Crit("cannot open boltdb", "subject", "boltdb")
+	// prepare boltdb and shard store
+	if err := OpenStorage(); err != nil {
+		log.Crit("open storage failed", "ERROR", err)
 		return
 	}
 	defer BoltDB.Close()
-	err = BoltDB.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(BucketContract))
-		if err != nil {
-			return err
+
+	if StartOpts.PidFile != "" {
+		if err := writePidFile(StartOpts.PidFile); err != nil {
+			log.Crit("write pid file failed", "ERROR", err)
+			return
 		}
-		_, err = tx.CreateBucketIfNotExists([]byte(BucketToken))
+		defer os.Remove(StartOpts.PidFile)
+	}
+
+	// check storage consistency
+	if Cfg.RepairOnStart != "" {
+		report, err := Repair(Cfg.RepairOnStart)
 		if err != nil {
-			return err
+			log.Crit("repair failed", "subject", "repair", "ERROR", err)
+			return
 		}
-		return nil
-	})
-	if err != nil {
-		log.Crit("create boltdb bucket error", "subject", "boltdb", "ERROR", err)
-		return
+		report.Log(log.New("module", "repair"))
 	}
 
+	SetupRateLimits(Cfg)
+	SetupTransferLimits(Cfg)
+
+	// cancelled on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	var node INode
 	node = &Farmer{}
-	if err := node.Init(Cfg); err != nil {
+	if err := node.Init(ctx, Cfg); err != nil {
 		log.Crit("node init failed", "ERROR", err)
 		return
 	}
 
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		TokenSweeper(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		ContractReaper(ctx)
+	}()
+
 	handler := &RegexpHandler{}
 	handler.HandleFunc(regexp.MustCompile(`^/$`), RootHandler(node))
 	handler.HandleFunc(regexp.MustCompile(`^/shards/\w+$`), ShardHandler())
@@ -74,41 +171,127 @@ func main() {
 		IdleTimeout: 1 * time.Second,
 	}
 
+	if Cfg.IsTLSEnabled() {
+		cert, err := LoadTLSCertificate(node.PrivateKey())
+		if err != nil {
+			log.Crit("load certificate failed", "subject", "tls", "error", err)
+			return
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
 	// start server
 	stopServer := make(chan struct{}, 1)
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if Cfg.IsTLSEnabled() {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Crit("listen error", "subject", "http", "error", err)
 			stopServer <- struct{}{}
 		}
 	}()
 
-	// start terminal ui
-	stopUi := make(chan struct{}, 1)
-	go func() {
-		err := UiSetup(ChanSize)
-		if err != nil {
-			log.Crit("init failed", "subject", "terminal", "error", err)
+	// start admin api
+	var adminServer *http.Server
+	if Cfg.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:        Cfg.AdminAddr,
+			Handler:     AdminHandler(ctx, node),
+			IdleTimeout: 10 * time.Second,
 		}
-		stopUi <- struct{}{}
-	}()
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("listen error", "subject", "admin", "error", err)
+			}
+		}()
+	}
+
+	// start metrics
+	var metricsServer *http.Server
+	if Cfg.MetricsAddr != "" {
+		metricsServer = &http.Server{
+			Addr:        Cfg.MetricsAddr,
+			Handler:     MetricsHandler(node),
+			IdleTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("listen error", "subject", "metrics", "error", err)
+			}
+		}()
+	}
+
+	// start terminal ui, or report status in daemon mode
+	stopUi := make(chan struct{}, 1)
+	if StartOpts.NoUi {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			StatusReporter(ctx, node)
+		}()
+	} else {
+		go func() {
+			err := UiSetup(node)
+			if err != nil {
+				log.Crit("init failed", "subject", "terminal", "error", err)
+			}
+			stopUi <- struct{}{}
+		}()
+	}
 
 	// heartbeat
+	workers.Add(1)
 	go func() {
+		defer workers.Done()
 		node.HeartBeat()
-		stopServer <- struct{}{}
 	}()
 
 	// wait
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	stopUiLoop := func() {
+		if !StartOpts.NoUi {
+			ui.StopLoop()
+		}
+	}
 	select {
 	case <-stopServer:
-		ui.StopLoop()
+		stopUiLoop()
 	case <-stopUi:
+	case sig := <-sigs:
+		log.Info("signal received", "subject", "shutdown", "signal", sig)
+		stopUiLoop()
 	}
+	signal.Stop(sigs)
 
-	// shutdown server gracefully
+	// shutdown server gracefully, uploads in flight are finished
 	log.Info("Shutting down the server...")
-	log.Info("Shutting down the server...")
-	ctx, _ := context.WithTimeout(context.Background(), time.Minute)
-	_ = server.Shutdown(ctx)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// abort uploads, partial files are removed by the shard store
+		log.Warn("shutdown server error", "subject", "shutdown", "error", err)
+		_ = server.Close()
+	}
+	if adminServer != nil {
+		_ = adminServer.Shutdown(shutdownCtx)
+	}
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(shutdownCtx)
+	}
+	shutdownCancel()
+
+	// stop subsystems: offers, mirrors, heartbeat and port mapping
+	log.Info("Stopping the farmer...")
+	cancel()
+	node.Shutdown()
+	workers.Wait()
+
+	if err := BoltDB.Close(); err != nil {
+		log.Warn("close boltdb error", "subject", "shutdown", "error", err)
+	}
+	log.Info("Shutdown complete")
 }
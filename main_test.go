@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/GenaroNetwork/go-farmer/config"
+	log "github.com/inconshreveable/log15"
+)
+
+// configure Cfg with a temporary data_dir and open storage,
+// the returned func closes storage and removes data_dir
+func setupTestStorage(t *testing.T) func() {
+	dataDir, err := ioutil.TempDir("", "go-farmer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Cfg = config.Config{
+		LocalAddr:  "127.0.0.1:4000",
+		PrivateKey: "e8b6a4ab9b5b7b9d5f0e2c1a3f4d6b8e0c2a4f6d8b0e2c4a6f8d0b2e4c6a8f0d",
+		DataDir:    dataDir,
+		LogDir:     dataDir,
+		Protocol:   "1.2.0",
+		SeedList:   []string{},
+	}
+	if err := Cfg.Parse(); err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatal(err)
+	}
+	if err := OpenStorage(); err != nil {
+		os.RemoveAll(dataDir)
+		t.Fatal(err)
+	}
+	SetupRateLimits(Cfg)
+	SetupTransferLimits(Cfg)
+	logger = log.New("module", "farmer")
+	logger.SetHandler(log.DiscardHandler())
+	return func() {
+		BoltDB.Close()
+		os.RemoveAll(dataDir)
+	}
+}
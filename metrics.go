@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+	"github.com/boltdb/bolt"
+)
+
+// metrics in prometheus text format, served on metrics_addr
+
+// buckets of durations in seconds
+var rpcBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var transferBuckets = []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900}
+
+var (
+	rpcRequests = newCounterVec("farmer_rpc_requests_total",
+		"RPC handled, by method and result.", "method", "result")
+	rpcDuration = newHistogramVec("farmer_rpc_duration_seconds",
+		"Time to handle RPC, by method.", rpcBuckets, "method")
+	shardRequests = newCounterVec("farmer_shard_requests_total",
+		"Requests of /shards, by HTTP method and status code.", "method", "code")
+	shardDuration = newHistogramVec("farmer_shard_duration_seconds",
+		"Time to serve requests of /shards, by HTTP method.", transferBuckets, "method")
+	auditHashDuration = newHistogramVec("farmer_audit_hash_seconds",
+		"Time to hash a shard for AUDIT.", rpcBuckets)
+	outboundRequests = newCounterVec("farmer_outbound_rpc_total",
+		"RPC sent, by peer (seed node id or other) and result.", "peer", "result")
+	outboundDuration = newHistogramVec("farmer_outbound_rpc_duration_seconds",
+		"Time of RPC sent, by peer (seed node id or other).", rpcBuckets, "peer")
+	contractEvents = newCounterVec("farmer_contracts_total",
+		"Contracts offered to and signed by renters.", "state")
+	shardEvents = newCounterVec("farmer_shards_total",
+		"Shards stored and deleted.", "op")
+	shardEventBytes = newCounterVec("farmer_shards_bytes_total",
+		"Bytes of shards stored and deleted.", "op")
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var metricsLock sync.Mutex
+var metrics []metric
+
+func registerMetric(m metric) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics = append(metrics, m)
+}
+
+// label values of a metric joined, as a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// counterVec counters partitioned by labels
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+	registerMetric(c)
+	return c
+}
+
+func (c *counterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *counterVec) Add(v float64, values ...string) {
+	key := labelKey(values)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[key] += v
+	c.keys[key] = values
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, formatLabels(c.labels, c.keys[key]), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec histograms partitioned by labels
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	registerMetric(h)
+	return h
+}
+
+func (h *histogramVec) Observe(v float64, values ...string) {
+	key := labelKey(values)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{labels: values, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, le := range append(append([]float64{}, h.buckets...), math.Inf(1)) {
+			if i < len(hist.counts) {
+				cumulative += hist.counts[i]
+			} else {
+				cumulative = hist.count
+			}
+			values := append(append([]string{}, hist.labels...), formatFloat(le))
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, formatLabels(bucketLabels, values), cumulative)
+		}
+		labels := formatLabels(h.labels, hist.labels)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, labels, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, labels, hist.count)
+	}
+}
+
+// funcMetric values read when scraped, keyed by value of label.
+// label is empty if there's a single value keyed by "".
+type funcMetric struct {
+	name  string
+	help  string
+	typ   string // "counter" or "gauge"
+	label string
+	fn    func() map[string]float64
+}
+
+func registerFuncMetric(name, help, typ, label string, fn func() map[string]float64) {
+	registerMetric(&funcMetric{name: name, help: help, typ: typ, label: label, fn: fn})
+}
+
+func (f *funcMetric) write(w io.Writer) {
+	values := f.fn()
+	if values == nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", f.name, f.help, f.name, f.typ)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		labels := ""
+		if f.label != "" {
+			labels = formatLabels([]string{f.label}, []string{key})
+		}
+		fmt.Fprintf(w, "%v%v %v\n", f.name, labels, formatFloat(values[key]))
+	}
+}
+
+func init() {
+	registerFuncMetric("farmer_shard_bytes_total", "Shard data uploaded to and downloaded from the farmer.", "counter", "direction",
+		func() map[string]float64 {
+			return map[string]float64{
+				TokenUpload:   float64(uploadMeter.Total()),
+				TokenDownload: float64(downloadMeter.Total()),
+			}
+		})
+	registerFuncMetric("farmer_audits_total", "AUDIT answered with a proof (pass) or an error (fail).", "counter", "result",
+		func() map[string]float64 {
+			return map[string]float64{
+				"pass": float64(atomic.LoadUint64(&auditPassed)),
+				"fail": float64(atomic.LoadUint64(&auditFailed)),
+			}
+		})
+	registerFuncMetric("farmer_events_dropped_total", "Events dropped for subscribers too slow to receive them.", "counter", "",
+		func() map[string]float64 {
+			return map[string]float64{"": float64(Events.Dropped())}
+		})
+	registerFuncMetric("farmer_transfers_active", "Shard transfers in progress.", "gauge", "direction",
+		func() map[string]float64 {
+			if uploadSlots == nil || downloadSlots == nil {
+				return nil
+			}
+			return map[string]float64{
+				TokenUpload:   float64(uploadSlots.Active()),
+				TokenDownload: float64(downloadSlots.Active()),
+			}
+		})
+	registerFuncMetric("farmer_boltdb_size_bytes", "Size of BoltDB file.", "gauge", "",
+		func() map[string]float64 {
+			fInfo, err := os.Stat(Cfg.GetContractDBPath())
+			if err != nil {
+				return nil
+			}
+			return map[string]float64{"": float64(fInfo.Size())}
+		})
+	registerFuncMetric("farmer_boltdb_keys", "Number of keys in BoltDB, by bucket.", "gauge", "bucket",
+		func() map[string]float64 {
+			values := make(map[string]float64)
+			err := BoltDB.View(func(tx *bolt.Tx) error {
+				return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+					values[string(name)] = float64(b.Stats().KeyN)
+					return nil
+				})
+			})
+			if err != nil {
+				return nil
+			}
+			return values
+		})
+}
+
+// method label of RPC, known methods only to bound cardinality
+func rpcMethodLabel(method string) string {
+	switch method {
+	case msg.MPing, msg.MProbe, msg.MFindNode, msg.MOffer, msg.MPublish,
+		msg.MConsign, msg.MRetrieve, msg.MMirror, msg.MAudit:
+		return method
+	default:
+		return "unknown"
+	}
+}
+
+// count and time RPC m handled since start
+func observeRpc(m *MsgInOut, res IMessage, start time.Time) {
+	method, _ := m.MsgInMap()["method"].(string)
+	method = rpcMethodLabel(method)
+	result := "ok"
+	if _, ok := res.(*msg.ResErr); ok {
+		result = "error"
+	}
+	rpcRequests.Inc(method, result)
+	rpcDuration.ObserveSince(start, method)
+}
+
+// peer label of RPC sent to c, seeds are the only peers with a label
+// of their own to keep the number of series bounded
+func peerLabel(c msg.Contact) string {
+	for _, seed := range Cfg.GetSeedList() {
+		if seed.NodeID == c.NodeID {
+			return c.NodeID
+		}
+	}
+	return "other"
+}
+
+// method label of /shards requests
+func httpMethodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST":
+		return method
+	default:
+		return "other"
+	}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// count shard of size stored or deleted. counted where it happens rather
+// than from Events, which drops events of slow subscribers
+func observeShard(op string, size int64) {
+	shardEvents.Inc(op)
+	shardEventBytes.Add(float64(size), op)
+}
+
+// serve metrics of node and the registered ones
+func MetricsHandler(node INode) http.Handler {
+	registerFuncMetric("farmer_network_state", "Connectivity of heartbeat, 1 for the current state.", "gauge", "state",
+		func() map[string]float64 {
+			values := make(map[string]float64)
+			current := node.NetState()
+			for _, s := range []NetState{NetJoining, NetConnected, NetDegraded, NetDisconnected} {
+				values[s.String()] = 0
+				if s == current {
+					values[s.String()] = 1
+				}
+			}
+			return values
+		})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		metricsLock.Lock()
+		ms := append([]metric{}, metrics...)
+		metricsLock.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range ms {
+			m.write(w)
+		}
+	})
+}
+
+// statusWriter records status code of response
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
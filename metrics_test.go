@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHistogramWrite(t *testing.T) {
+	// not registered, so not served by MetricsHandler
+	h := &histogramVec{
+		name:    "test_seconds",
+		help:    "Test histogram.",
+		labels:  []string{"method"},
+		buckets: []float64{.1, 1, 10},
+		values:  make(map[string]*histogram),
+	}
+	for _, v := range []float64{.05, .1, .5, 2, 20} {
+		h.Observe(v, "GET")
+	}
+	h.Observe(.5, "POST")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{method="GET",le="0.1"} 2
+test_seconds_bucket{method="GET",le="1"} 3
+test_seconds_bucket{method="GET",le="10"} 4
+test_seconds_bucket{method="GET",le="+Inf"} 5
+test_seconds_sum{method="GET"} 22.65
+test_seconds_count{method="GET"} 5
+test_seconds_bucket{method="POST",le="0.1"} 0
+test_seconds_bucket{method="POST",le="1"} 1
+test_seconds_bucket{method="POST",le="10"} 1
+test_seconds_bucket{method="POST",le="+Inf"} 1
+test_seconds_sum{method="POST"} 0.5
+test_seconds_count{method="POST"} 1
+`
+	if buf.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", buf.String(), want)
+	}
+}
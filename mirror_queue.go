@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+	"github.com/boltdb/bolt"
+)
+
+// states of mirror job
+const (
+	MirrorPending = "pending"
+	MirrorRunning = "running"
+	MirrorDone    = "done"
+	MirrorFailed  = "failed"
+)
+
+const mirrorJobAttempts = 3
+const mirrorRetryDelay = time.Minute
+
+var ErrMirrorJobExist = errors.New("mirror job already exist")
+
+// mirror job saved in BucketMirror
+type mirrorJob struct {
+	DataHash string      `json:"data_hash"`
+	Farmer   msg.Contact `json:"farmer"` // source farmer
+	Token    string      `json:"token"`
+	Trees    []string    `json:"trees"`
+	Size     int64       `json:"size"`
+	Attempts int         `json:"attempts"`
+	State    string      `json:"state"`
+	Error    string      `json:"error"`
+	Created  int64       `json:"created"` // unix time
+	Updated  int64       `json:"updated"` // unix time
+}
+
+func getMirrorJob(dataHash string) (mirrorJob, error) {
+	var job mirrorJob
+	raw, err := BoltDbGet([]byte(dataHash), BucketMirror)
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(raw, &job)
+	return job, err
+}
+
+func saveMirrorJob(job *mirrorJob) error {
+	job.Updated = time.Now().Unix()
+	js, _ := json.Marshal(job)
+	if err := BoltDbSet([]byte(job.DataHash), js, BucketMirror, true); err != nil {
+		return err
+	}
+	Events.Publish(EventMirror{
+		DataHash: job.DataHash,
+		State:    job.State,
+		Attempts: job.Attempts,
+		Error:    job.Error,
+	})
+	return nil
+}
+
+// all mirror jobs, none if BucketMirror does not exist
+func listMirrorJobs() ([]mirrorJob, error) {
+	jobs := []mirrorJob{}
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketMirror))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var job mirrorJob
+			if err := json.Unmarshal(v, &job); err == nil {
+				jobs = append(jobs, job)
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// MirrorQueue downloads shards of mirror jobs by a pool of workers,
+// jobs are persisted so they are resumed after restart
+type MirrorQueue struct {
+	jobs        chan string
+	concurrency int
+	limiter     *rateLimiter
+	ctx         context.Context
+	wg          sync.WaitGroup
+}
+
+func NewMirrorQueue(concurrency int, bandwidth int64) *MirrorQueue {
+	return &MirrorQueue{
+		jobs:        make(chan string),
+		concurrency: concurrency,
+		limiter:     newRateLimiter(bandwidth),
+	}
+}
+
+// start workers and resume unfinished jobs. workers stop when ctx
+// is done, and jobs interrupted are resumed after restart.
+func (q *MirrorQueue) Start(ctx context.Context) error {
+	q.ctx = ctx
+	for i := 0; i < q.concurrency; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	jobs, err := listMirrorJobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.State == MirrorPending || job.State == MirrorRunning {
+			q.push(job.DataHash, 0)
+		}
+	}
+	return nil
+}
+
+// add a job, fails if a job of data_hash is pending or running
+func (q *MirrorQueue) Add(farmer msg.Contact, dataHash, token string, trees []string, size int64) error {
+	if job, err := getMirrorJob(dataHash); err == nil {
+		if job.State == MirrorPending || job.State == MirrorRunning {
+			return ErrMirrorJobExist
+		}
+	}
+	now := time.Now().Unix()
+	job := mirrorJob{
+		DataHash: dataHash,
+		Farmer:   farmer,
+		Token:    token,
+		Trees:    trees,
+		Size:     size,
+		State:    MirrorPending,
+		Created:  now,
+	}
+	if err := saveMirrorJob(&job); err != nil {
+		return err
+	}
+	q.push(dataHash, 0)
+	return nil
+}
+
+// wait for workers to stop
+func (q *MirrorQueue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *MirrorQueue) push(dataHash string, delay time.Duration) {
+	go func() {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		select {
+		case <-q.ctx.Done():
+		case q.jobs <- dataHash:
+		}
+	}()
+}
+
+func (q *MirrorQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case dataHash := <-q.jobs:
+			q.process(dataHash)
+		}
+	}
+}
+
+func (q *MirrorQueue) process(dataHash string) {
+	logger := logger.New("subject", "mirror")
+	job, err := getMirrorJob(dataHash)
+	if err != nil {
+		logger.Warn("get mirror job error", "data_hash", dataHash, "error", err)
+		return
+	}
+	job.State = MirrorRunning
+	job.Attempts++
+	if err := saveMirrorJob(&job); err != nil {
+		logger.Warn("save mirror job error", "data_hash", dataHash, "error", err)
+	}
+
+	logger.Info("mirroring shard", "data_hash", dataHash, "attempt", job.Attempts)
+	err = DownloadShard(q.ctx, job.Farmer, dataHash, job.Token, job.Size, q.limiter)
+	// shard already on disk is not stored again
+	imported := err == nil
+	if err == nil || err == ErrShardExist {
+		err = saveTrees(dataHash, job.Trees)
+	}
+	if err != nil && q.ctx.Err() != nil {
+		// shutting down, not counted as an attempt
+		logger.Info("mirror shard interrupted", "data_hash", dataHash)
+		job.State = MirrorPending
+		job.Attempts--
+	} else if err != nil {
+		logger.Warn("mirror shard error", "data_hash", dataHash, "attempt", job.Attempts, "error", err)
+		job.Error = err.Error()
+		if job.Attempts < mirrorJobAttempts {
+			job.State = MirrorPending
+			q.push(dataHash, mirrorRetryDelay)
+		} else {
+			job.State = MirrorFailed
+		}
+	} else {
+		logger.Info("mirror shard success", "data_hash", dataHash)
+		job.State = MirrorDone
+		job.Error = ""
+		if imported {
+			addStoredBytes(job.Size)
+			observeShard("stored", job.Size)
+			Events.Publish(EventShardStored{DataHash: dataHash, Size: job.Size})
+		}
+	}
+	if err := saveMirrorJob(&job); err != nil {
+		logger.Warn("save mirror job error", "data_hash", dataHash, "error", err)
+	}
+}
+
+// save audit trees of shard in its storageItem
+func saveTrees(dataHash string, trees []string) error {
+	sItem, err := getStorageItem(dataHash)
+	if err != nil {
+		return err
+	}
+	sItem.Trees = trees
+	js, _ := json.Marshal(sItem)
+	return BoltDbSet([]byte(dataHash), js, BucketContract, true)
+}
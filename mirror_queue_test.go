@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestMirrorShardExist(t *testing.T) {
+	defer setupTestStorage(t)()
+	dataHash := saveTestShard(t, []byte("mirrored shard"), time.Now().Add(time.Hour))
+	job := mirrorJob{DataHash: dataHash, Trees: []string{"mirrored tree"}, Size: 14, State: MirrorPending}
+	if err := saveMirrorJob(&job); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&storedBytes, 0)
+
+	q := NewMirrorQueue(1, 0)
+	q.ctx = context.Background()
+	q.process(dataHash)
+
+	job, err := getMirrorJob(dataHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != MirrorDone {
+		t.Errorf("job state %v, want %v", job.State, MirrorDone)
+	}
+	sItem, _ := getStorageItem(dataHash)
+	if len(sItem.Trees) != 1 || sItem.Trees[0] != "mirrored tree" {
+		t.Errorf("trees %v not saved", sItem.Trees)
+	}
+	// the shard was stored before, not by the job
+	if n := atomic.LoadInt64(&storedBytes); n != 0 {
+		t.Errorf("stored bytes %v, want 0", n)
+	}
+}
+
+func TestListMirrorJobsWithoutBucket(t *testing.T) {
+	defer setupTestStorage(t)()
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(BucketMirror))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := listMirrorJobs()
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("listMirrorJobs = %v, %v, want no jobs", jobs, err)
+	}
+}
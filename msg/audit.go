@@ -38,3 +38,15 @@ func (m *Audit) SetNonce(n int) {
 func (m *Audit) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Audit) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Audit) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Audit) GetContact() Contact {
+	return m.Params.Contact
+}
@@ -2,6 +2,7 @@ package msg
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -26,6 +27,13 @@ type Contact struct {
 	Protocol string `json:"protocol"`
 }
 
+// suffix of protocol announcing that the node serves https
+const TLSProtocolSuffix = "+tls"
+
+func (c *Contact) IsTLS() bool {
+	return strings.HasSuffix(c.Protocol, TLSProtocolSuffix)
+}
+
 func (c *Contact) IsValid() bool {
 	if c.Address == "" || c.Port == 0 || c.NodeID == "" || c.Protocol == "" {
 		return false
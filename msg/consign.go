@@ -34,3 +34,15 @@ func (m *Consign) SetNonce(n int) {
 func (m *Consign) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Consign) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Consign) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Consign) GetContact() Contact {
+	return m.Params.Contact
+}
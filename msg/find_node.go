@@ -33,3 +33,15 @@ func (m *FindNode) SetNonce(n int) {
 func (m *FindNode) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *FindNode) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *FindNode) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *FindNode) GetContact() Contact {
+	return m.Params.Contact
+}
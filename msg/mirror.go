@@ -36,3 +36,15 @@ func (m *Mirror) SetNonce(n int) {
 func (m *Mirror) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Mirror) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Mirror) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Mirror) GetContact() Contact {
+	return m.Params.Contact
+}
@@ -33,3 +33,15 @@ func (m *Offer) SetNonce(n int) {
 func (m *Offer) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Offer) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Offer) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Offer) GetContact() Contact {
+	return m.Params.Contact
+}
@@ -32,3 +32,15 @@ func (m *Ping) SetNonce(n int) {
 func (m *Ping) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Ping) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Ping) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Ping) GetContact() Contact {
+	return m.Params.Contact
+}
@@ -32,3 +32,15 @@ func (m *Probe) SetNonce(n int) {
 func (m *Probe) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Probe) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Probe) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Probe) GetContact() Contact {
+	return m.Params.Contact
+}
@@ -37,3 +37,15 @@ func (m *Publish) SetNonce(n int) {
 func (m *Publish) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Publish) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Publish) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Publish) GetContact() Contact {
+	return m.Params.Contact
+}
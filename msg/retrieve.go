@@ -33,3 +33,15 @@ func (m *Retrieve) SetNonce(n int) {
 func (m *Retrieve) SetSignature(sig string) {
 	m.Params.Signature = sig
 }
+
+func (m *Retrieve) GetNonce() int {
+	return m.Params.Nonce
+}
+
+func (m *Retrieve) GetSignature() string {
+	return m.Params.Signature
+}
+
+func (m *Retrieve) GetContact() Contact {
+	return m.Params.Contact
+}
@@ -1,5 +1,9 @@
 package main
 
+import (
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
 // IMessage message
 type IMessage interface {
 	IsValid() bool
@@ -8,3 +12,11 @@ type IMessage interface {
 	SetNonce(int)
 	SetSignature(string)
 }
+
+// IRequest request message signed by its sender
+type IRequest interface {
+	IMessage
+	GetNonce() int
+	GetSignature() string
+	GetContact() msg.Contact
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+
 	"github.com/GenaroNetwork/go-farmer/config"
 	"github.com/GenaroNetwork/go-farmer/crypto"
 	"github.com/GenaroNetwork/go-farmer/msg"
 )
 
 type INode interface {
-	Init(config.Config) error
+	Init(context.Context, config.Config) error
+	// wait for work in flight after context is done
+	Shutdown()
 
 	Contact() msg.Contact
 	SetContact(msg.Contact)
@@ -18,5 +22,8 @@ type INode interface {
 	Sign(IMessage)
 	ProcessMsgInOut(*MsgInOut)
 
+	// returns when context is done
 	HeartBeat()
+	NetState() NetState
+	Peers() int
 }
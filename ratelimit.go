@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/config"
+)
+
+// global limits of shard transfers. like uploadSlots and downloadSlots,
+// upload is shard data stored into us (CONSIGN and mirror), download is
+// shard data served by us (RETRIEVE).
+var uploadLimiter, downloadLimiter *rateLimiter
+
+// bytes of shard transfers
+var uploadMeter, downloadMeter transferMeter
+
+func SetupRateLimits(cfg config.Config) {
+	uploadLimiter = newRateLimiter(cfg.GetUploadRate())
+	downloadLimiter = newRateLimiter(cfg.GetDownloadRate())
+}
+
+// limiters of a new upload stream
+func uploadLimiters() []*rateLimiter {
+	return []*rateLimiter{uploadLimiter, newRateLimiter(Cfg.GetUploadRatePerConn())}
+}
+
+// limiters of a new download stream
+func downloadLimiters() []*rateLimiter {
+	return []*rateLimiter{downloadLimiter, newRateLimiter(Cfg.GetDownloadRatePerConn())}
+}
+
+// transferMeter counts bytes transferred
+type transferMeter struct {
+	bytes int64
+}
+
+func (m *transferMeter) Add(n int) {
+	if m != nil {
+		atomic.AddInt64(&m.bytes, int64(n))
+	}
+}
+
+func (m *transferMeter) Total() int64 {
+	return atomic.LoadInt64(&m.bytes)
+}
+
+// rateLimiter token bucket of bytes, shared by streams.
+// a nil rateLimiter is unlimited.
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   int64 // bytes per second, also the bucket size
+	tokens float64
+	last   time.Time
+}
+
+// nil if rate <= 0
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// take n tokens, sleep til they are available
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	// tokens may go negative, later callers wait longer
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.lock.Unlock()
+	time.Sleep(d)
+}
+
+// limitedReader reads no faster than any of its limiters,
+// bytes read are counted by meter
+type limitedReader struct {
+	r        io.Reader
+	meter    *transferMeter
+	limiters []*rateLimiter
+	chunk    int
+}
+
+func newLimitedReader(r io.Reader, meter *transferMeter, limiters ...*rateLimiter) io.Reader {
+	lr := &limitedReader{r: r, meter: meter, chunk: 32 * int(KB)}
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		lr.limiters = append(lr.limiters, l)
+		if int(l.rate) < lr.chunk {
+			lr.chunk = int(l.rate)
+		}
+	}
+	return lr
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.chunk {
+		p = p[:lr.chunk]
+	}
+	n, err := lr.r.Read(p)
+	lr.meter.Add(n)
+	for _, l := range lr.limiters {
+		l.wait(n)
+	}
+	return n, err
+}
+
+// limitedShardReader limits reading of a shard, keeps it seekable
+type limitedShardReader struct {
+	ShardReader
+	lr io.Reader
+}
+
+func newLimitedShardReader(shard ShardReader, meter *transferMeter, limiters ...*rateLimiter) ShardReader {
+	return &limitedShardReader{
+		ShardReader: shard,
+		lr:          newLimitedReader(shard, meter, limiters...),
+	}
+}
+
+func (r *limitedShardReader) Read(p []byte) (int, error) {
+	return r.lr.Read(p)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// read size bytes through limiters, returns time taken
+func timedRead(t *testing.T, size int, meter *transferMeter, limiters ...*rateLimiter) time.Duration {
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, newLimitedReader(bytes.NewReader(make([]byte, size)), meter, limiters...))
+	if err != nil || n != int64(size) {
+		t.Fatalf("read %v bytes, error %v", n, err)
+	}
+	return time.Since(start)
+}
+
+func TestRateLimiter(t *testing.T) {
+	// bucket starts full, the rest is read at rate: 2 seconds
+	rate := int64(200 * KB)
+	var meter transferMeter
+	d := timedRead(t, int(3*rate), &meter, newRateLimiter(rate))
+	if d < 1800*time.Millisecond || d > 3*time.Second {
+		t.Errorf("read 3x rate in %v, want about 2s", d)
+	}
+	if meter.Total() != 3*rate {
+		t.Errorf("meter %v, want %v", meter.Total(), 3*rate)
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	// two streams share one limiter, the slower per-stream one wins
+	rate := int64(200 * KB)
+	shared := newRateLimiter(rate)
+	done := make(chan time.Duration)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- timedRead(t, int(rate), nil, shared, newRateLimiter(10*rate))
+		}()
+	}
+	d := <-done
+	if d2 := <-done; d2 > d {
+		d = d2
+	}
+	if d < 800*time.Millisecond || d > 2*time.Second {
+		t.Errorf("read 2x rate by 2 streams in %v, want about 1s", d)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Error("zero rate is limited")
+	}
+	if l := newRateLimiter(-1); l != nil {
+		t.Error("negative rate is limited")
+	}
+	if d := timedRead(t, int(50*MB), nil, nil, newRateLimiter(0)); d > time.Second {
+		t.Errorf("unlimited read took %v", d)
+	}
+
+	// unset rates are unlimited
+	defer setupTestStorage(t)()
+	if uploadLimiter != nil || downloadLimiter != nil {
+		t.Error("unset upload_rate or download_rate is limited")
+	}
+	for _, l := range append(uploadLimiters(), downloadLimiters()...) {
+		if l != nil {
+			t.Error("unset per connection rate is limited")
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const reapInterval = 10 * time.Minute
+
+// data_hash of contracts whose store_end plus grace period has passed
+func expiredContracts(now time.Time, grace time.Duration) ([]string, error) {
+	var dataHashes []string
+	// store_end is in milliseconds
+	deadline := now.Add(-grace).UnixNano() / int64(time.Millisecond)
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketContract))
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var sItem storageItem
+			if err := json.Unmarshal(v, &sItem); err != nil {
+				continue
+			}
+			if int64(sItem.Contract.StoreEnd) < deadline {
+				dataHashes = append(dataHashes, string(k))
+			}
+		}
+		return nil
+	})
+	return dataHashes, err
+}
+
+// move contract from BucketContract to BucketArchive
+func archiveContract(dataHash string) error {
+	var sItem storageItem
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketContract))
+		v := b.Get([]byte(dataHash))
+		if v == nil {
+			return nil
+		}
+		_ = json.Unmarshal(v, &sItem)
+		archive, err := tx.CreateBucketIfNotExists([]byte(BucketArchive))
+		if err != nil {
+			return err
+		}
+		if err := archive.Put([]byte(dataHash), v); err != nil {
+			return err
+		}
+		return b.Delete([]byte(dataHash))
+	})
+	if err == nil {
+		addCommittedBytes(-int64(sItem.Contract.DataSize))
+	}
+	return err
+}
+
+// delete shards, tokens and contracts expired, returns bytes reclaimed
+func reapContracts() (int64, error) {
+	logger := logger.New("subject", "reaper")
+	dataHashes, err := expiredContracts(time.Now(), Cfg.GetContractGracePeriod())
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64 = 0
+	for _, dataHash := range dataHashes {
+		// shard
+		size, err := Shards.Stat(dataHash)
+		if err == nil {
+			if err := Shards.Delete(dataHash); err != nil {
+				logger.Warn("delete shard error", "data_hash", dataHash, "error", err)
+				continue
+			}
+			reclaimed += size
+			addStoredBytes(-size)
+			observeShard("deleted", size)
+			Events.Publish(EventShardDeleted{DataHash: dataHash, Size: size})
+		} else if err != ErrShardNotFound {
+			logger.Warn("stat shard error", "data_hash", dataHash, "error", err)
+			continue
+		}
+
+		// tokens
+		if _, err := deleteTokensOf(dataHash); err != nil {
+			logger.Warn("delete tokens error", "data_hash", dataHash, "error", err)
+		}
+
+		// contract
+		if err := archiveContract(dataHash); err != nil {
+			logger.Warn("archive contract error", "data_hash", dataHash, "error", err)
+			continue
+		}
+		logger.Info("contract expired", "data_hash", dataHash, "size", size)
+	}
+	return reclaimed, nil
+}
+
+// reap expired contracts periodically until ctx is done
+func ContractReaper(ctx context.Context) {
+	logger := logger.New("subject", "reaper")
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reclaimed, err := reapContracts()
+		if err != nil {
+			logger.Warn("reap contracts error", "error", err)
+			continue
+		}
+		if reclaimed > 0 {
+			logger.Info("space reclaimed", "size", reclaimed)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/crypto"
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+// save shard of content and its contract ending at storeEnd,
+// returns data_hash
+func saveTestShard(t *testing.T, content []byte, storeEnd time.Time) string {
+	dataHash := hex.EncodeToString(crypto.Ripemd160Sha256(content))
+	if _, err := Shards.Put(dataHash, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	js, _ := json.Marshal(storageItem{
+		Contract: msg.Contract{
+			DataHash: dataHash,
+			DataSize: len(content),
+			StoreEnd: int(storeEnd.UnixNano() / int64(time.Millisecond)),
+		},
+		Trees: []string{"tree"},
+	})
+	if err := BoltDbSet([]byte(dataHash), js, BucketContract, false); err != nil {
+		t.Fatal(err)
+	}
+	return dataHash
+}
+
+func TestReapContracts(t *testing.T) {
+	defer setupTestStorage(t)()
+	now := time.Now()
+	grace := Cfg.GetContractGracePeriod()
+	expired := saveTestShard(t, []byte("expired shard"), now.Add(-grace-time.Hour))
+	inGrace := saveTestShard(t, []byte("shard in grace period"), now.Add(-grace+time.Hour))
+	live := saveTestShard(t, []byte("live shard"), now.Add(time.Hour))
+	js, _ := json.Marshal(tokenItem{DataHash: expired, Operation: TokenDownload, Expire: now.Add(time.Hour).Unix()})
+	if err := BoltDbSet([]byte("token"), js, BucketToken, false); err != nil {
+		t.Fatal(err)
+	}
+
+	dataHashes, err := expiredContracts(now, grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataHashes) != 1 || dataHashes[0] != expired {
+		t.Errorf("expiredContracts = %v, want [%v]", dataHashes, expired)
+	}
+
+	reclaimed, err := reapContracts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != int64(len("expired shard")) {
+		t.Errorf("reclaimed %v bytes, want %v", reclaimed, len("expired shard"))
+	}
+	if _, err := Shards.Stat(expired); err != ErrShardNotFound {
+		t.Errorf("shard of expired contract kept: %v", err)
+	}
+	if _, err := BoltDbGet([]byte(expired), BucketContract); err == nil {
+		t.Error("expired contract kept")
+	}
+	if _, err := BoltDbGet([]byte(expired), BucketArchive); err != nil {
+		t.Errorf("expired contract not archived: %v", err)
+	}
+	if _, err := getToken("token"); err == nil {
+		t.Error("token of expired contract kept")
+	}
+	for _, dataHash := range []string{inGrace, live} {
+		if _, err := Shards.Stat(dataHash); err != nil {
+			t.Errorf("shard %v deleted: %v", dataHash, err)
+		}
+		if _, err := BoltDbGet([]byte(dataHash), BucketContract); err != nil {
+			t.Errorf("contract %v deleted: %v", dataHash, err)
+		}
+		if _, err := BoltDbGet([]byte(dataHash), BucketArchive); err == nil {
+			t.Errorf("contract %v archived", dataHash)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/boltdb/bolt"
+	log "github.com/inconshreveable/log15"
+)
+
+// actions of Repair
+const (
+	RepairActionReport     = "report"     // only report inconsistencies
+	RepairActionQuarantine = "quarantine" // move orphan shards to quarantine dir
+	RepairActionDelete     = "delete"     // delete orphan shards
+)
+
+// inconsistencies between BucketContract and the shard store
+type RepairReport struct {
+	// shards no contract is found for
+	ShardsWithoutContract []string `json:"shards_without_contract"`
+	// contracts consigned (have trees), but shard is not found
+	ContractsWithoutShard []string `json:"contracts_without_shard"`
+	// shard is stored, but contract has no trees to answer audits
+	ContractsWithoutTrees []string `json:"contracts_without_trees"`
+	// shard size is not data_size of contract
+	SizeMismatch []string `json:"size_mismatch"`
+	// partial files left in tmp dir, except downloads of mirror
+	// jobs to resume
+	TmpFiles []string `json:"tmp_files"`
+
+	// shards and tmp files quarantined or deleted
+	Repaired []string `json:"repaired"`
+}
+
+func (r *RepairReport) Log(logger log.Logger) {
+	for _, dataHash := range r.ShardsWithoutContract {
+		logger.Warn("shard without contract", "data_hash", dataHash)
+	}
+	for _, dataHash := range r.ContractsWithoutShard {
+		logger.Warn("contract without shard", "data_hash", dataHash)
+	}
+	for _, dataHash := range r.ContractsWithoutTrees {
+		logger.Warn("contract without trees", "data_hash", dataHash)
+	}
+	for _, dataHash := range r.SizeMismatch {
+		logger.Warn("shard size mismatch", "data_hash", dataHash)
+	}
+	for _, name := range r.TmpFiles {
+		logger.Warn("partial file in tmp dir", "name", name)
+	}
+	for _, name := range r.Repaired {
+		logger.Info("repaired", "name", name)
+	}
+	logger.Info("repair finished",
+		"shards_without_contract", len(r.ShardsWithoutContract),
+		"contracts_without_shard", len(r.ContractsWithoutShard),
+		"contracts_without_trees", len(r.ContractsWithoutTrees),
+		"size_mismatch", len(r.SizeMismatch),
+		"tmp_files", len(r.TmpFiles),
+		"repaired", len(r.Repaired))
+}
+
+// scan BoltDB and the shard store for inconsistencies, and quarantine
+// or delete orphan shards by action. shards must not be in use.
+func Repair(action string) (*RepairReport, error) {
+	switch action {
+	case RepairActionReport, RepairActionQuarantine, RepairActionDelete:
+	default:
+		return nil, fmt.Errorf("unknown repair action: %v", action)
+	}
+	report := &RepairReport{
+		ShardsWithoutContract: []string{},
+		ContractsWithoutShard: []string{},
+		ContractsWithoutTrees: []string{},
+		SizeMismatch:          []string{},
+		TmpFiles:              []string{},
+		Repaired:              []string{},
+	}
+
+	// shards
+	dataHashes, err := Shards.List()
+	if err != nil {
+		return nil, fmt.Errorf("list shards error: %v", err)
+	}
+	shardSizes := make(map[string]int64)
+	for _, dataHash := range dataHashes {
+		size, err := Shards.Stat(dataHash)
+		if err != nil {
+			return nil, fmt.Errorf("stat shard error: %v", err)
+		}
+		shardSizes[dataHash] = size
+	}
+
+	// contracts
+	contracts := make(map[string]bool)
+	err = BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketContract))
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			dataHash := string(k)
+			contracts[dataHash] = true
+			var sItem storageItem
+			if err := json.Unmarshal(v, &sItem); err != nil {
+				return fmt.Errorf("contract %v bad format: %v", dataHash, err)
+			}
+			size, hasShard := shardSizes[dataHash]
+			hasTrees := len(sItem.Trees) != 0
+			if hasTrees && !hasShard {
+				report.ContractsWithoutShard = append(report.ContractsWithoutShard, dataHash)
+			}
+			if hasShard && !hasTrees {
+				report.ContractsWithoutTrees = append(report.ContractsWithoutTrees, dataHash)
+			}
+			if hasShard && size != int64(sItem.Contract.DataSize) {
+				report.SizeMismatch = append(report.SizeMismatch, dataHash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, dataHash := range dataHashes {
+		if !contracts[dataHash] {
+			report.ShardsWithoutContract = append(report.ShardsWithoutContract, dataHash)
+		}
+	}
+
+	// partial files
+	jobs, err := listMirrorJobs()
+	if err != nil {
+		return nil, fmt.Errorf("list mirror jobs error: %v", err)
+	}
+	resumable := make(map[string]bool)
+	for _, job := range jobs {
+		if job.State == MirrorPending || job.State == MirrorRunning {
+			resumable[mirrorTmpName(job.DataHash)] = true
+		}
+	}
+	fInfos, err := ioutil.ReadDir(Cfg.GetTmpPath())
+	if err != nil {
+		return nil, fmt.Errorf("read tmp dir error: %v", err)
+	}
+	for _, fInfo := range fInfos {
+		if resumable[fInfo.Name()] {
+			continue
+		}
+		report.TmpFiles = append(report.TmpFiles, fInfo.Name())
+	}
+
+	if action == RepairActionReport {
+		return report, nil
+	}
+
+	// orphan and broken shards
+	orphans := append(append([]string{}, report.ShardsWithoutContract...), report.SizeMismatch...)
+	for _, dataHash := range orphans {
+		if action == RepairActionQuarantine {
+			err = quarantineShard(dataHash)
+		} else {
+			err = Shards.Delete(dataHash)
+		}
+		if err != nil {
+			return report, fmt.Errorf("repair shard %v error: %v", dataHash, err)
+		}
+		report.Repaired = append(report.Repaired, dataHash)
+	}
+	for _, name := range report.TmpFiles {
+		if err := os.RemoveAll(path.Join(Cfg.GetTmpPath(), name)); err != nil {
+			return report, fmt.Errorf("remove tmp file %v error: %v", name, err)
+		}
+		report.Repaired = append(report.Repaired, path.Join("tmp", name))
+	}
+	return report, nil
+}
+
+// move shard out of the shard store into quarantine dir
+func quarantineShard(dataHash string) error {
+	dir := Cfg.GetQuarantinePath()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	shard, err := Shards.Get(dataHash)
+	if err != nil {
+		return err
+	}
+	defer shard.Close()
+	fHandle, err := os.Create(path.Join(dir, dataHash))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fHandle, shard)
+	cErr := fHandle.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	return Shards.Delete(dataHash)
+}
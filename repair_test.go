@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+// shards and contracts of every inconsistency found by Repair
+type repairFixture struct {
+	ok, orphan, mismatch, noShard, noTrees string
+	mirrorTmp                              string
+}
+
+func setupRepairFixture(t *testing.T) repairFixture {
+	var fx repairFixture
+	end := time.Now().Add(time.Hour)
+	fx.ok = saveTestShard(t, []byte("consistent shard"), end)
+
+	fx.orphan = saveTestShard(t, []byte("orphan shard"), end)
+	if err := BoltDbDelete([]byte(fx.orphan), BucketContract); err != nil {
+		t.Fatal(err)
+	}
+
+	fx.mismatch = saveTestShard(t, []byte("shard of other size"), end)
+	sItem, _ := getStorageItem(fx.mismatch)
+	sItem.Contract.DataSize++
+	js, _ := json.Marshal(sItem)
+	if err := BoltDbSet([]byte(fx.mismatch), js, BucketContract, true); err != nil {
+		t.Fatal(err)
+	}
+
+	fx.noShard = saveTestShard(t, []byte("lost shard"), end)
+	if err := Shards.Delete(fx.noShard); err != nil {
+		t.Fatal(err)
+	}
+
+	fx.noTrees = saveTestShard(t, []byte("shard without trees"), end)
+	if err := saveTrees(fx.noTrees, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// partial files, one of a mirror job to resume
+	tmp := Cfg.GetTmpPath()
+	if err := ioutil.WriteFile(path.Join(tmp, "partial"), []byte("part"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mirrored := "0123456789abcdef0123456789abcdef01234567"
+	fx.mirrorTmp = mirrorTmpName(mirrored)
+	if err := ioutil.WriteFile(path.Join(tmp, fx.mirrorTmp), []byte("part"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	job := mirrorJob{DataHash: mirrored, Farmer: msg.Contact{}, Size: 100, State: MirrorPending}
+	if err := saveMirrorJob(&job); err != nil {
+		t.Fatal(err)
+	}
+	return fx
+}
+
+func sortedEqual(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRepair(t *testing.T) {
+	for _, action := range []string{RepairActionReport, RepairActionQuarantine, RepairActionDelete} {
+		t.Run(action, func(t *testing.T) {
+			defer setupTestStorage(t)()
+			fx := setupRepairFixture(t)
+
+			report, err := Repair(action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checks := []struct {
+				name      string
+				got, want []string
+			}{
+				{"shards without contract", report.ShardsWithoutContract, []string{fx.orphan}},
+				{"contracts without shard", report.ContractsWithoutShard, []string{fx.noShard}},
+				{"contracts without trees", report.ContractsWithoutTrees, []string{fx.noTrees}},
+				{"size mismatch", report.SizeMismatch, []string{fx.mismatch}},
+				{"tmp files", report.TmpFiles, []string{"partial"}},
+			}
+			for _, c := range checks {
+				if !sortedEqual(c.got, c.want) {
+					t.Errorf("%v %v, want %v", c.name, c.got, c.want)
+				}
+			}
+
+			// resumable partial download is always kept
+			if _, err := os.Stat(path.Join(Cfg.GetTmpPath(), fx.mirrorTmp)); err != nil {
+				t.Errorf("partial download of mirror job removed: %v", err)
+			}
+			if _, err := Shards.Stat(fx.ok); err != nil {
+				t.Errorf("consistent shard removed: %v", err)
+			}
+			_, tmpErr := os.Stat(path.Join(Cfg.GetTmpPath(), "partial"))
+
+			if action == RepairActionReport {
+				if len(report.Repaired) != 0 {
+					t.Errorf("report repaired %v", report.Repaired)
+				}
+				for _, dataHash := range []string{fx.orphan, fx.mismatch} {
+					if _, err := Shards.Stat(dataHash); err != nil {
+						t.Errorf("report removed shard %v", dataHash)
+					}
+				}
+				if tmpErr != nil {
+					t.Error("report removed tmp file")
+				}
+				return
+			}
+
+			if !sortedEqual(report.Repaired, []string{fx.orphan, fx.mismatch, path.Join("tmp", "partial")}) {
+				t.Errorf("repaired %v", report.Repaired)
+			}
+			if !os.IsNotExist(tmpErr) {
+				t.Errorf("tmp file kept: %v", tmpErr)
+			}
+			for _, dataHash := range []string{fx.orphan, fx.mismatch} {
+				if _, err := Shards.Stat(dataHash); err != ErrShardNotFound {
+					t.Errorf("shard %v kept in shard store: %v", dataHash, err)
+				}
+				quarantined, err := ioutil.ReadFile(path.Join(Cfg.GetQuarantinePath(), dataHash))
+				if action == RepairActionQuarantine && err != nil {
+					t.Errorf("shard %v not quarantined: %v", dataHash, err)
+				}
+				if action == RepairActionQuarantine && dataHash == fx.orphan && !bytes.Equal(quarantined, []byte("orphan shard")) {
+					t.Errorf("quarantined shard content %q", quarantined)
+				}
+				if action == RepairActionDelete && err == nil {
+					t.Errorf("shard %v quarantined by delete", dataHash)
+				}
+			}
+		})
+	}
+}
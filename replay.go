@@ -0,0 +1,76 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// max number of messages remembered for replay detection. messages are
+// only forgotten when out of nonce window, new messages are rejected
+// while full
+const maxReplayItems = 100000
+
+// max number of messages remembered per sender, messages of a sender
+// over quota are rejected until its oldest ones expire
+const maxReplayItemsPerSender = 10000
+
+// a message remembered until expire
+type replayItem struct {
+	key    string
+	sender string
+	expire time.Time
+}
+
+// messages received within ttl. items are kept in arrival order, which
+// is also expiry order, so expiring only touches the oldest
+type replayFilter struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	items   *list.List
+	keys    map[string]*list.Element
+	senders map[string]int
+}
+
+var replayCache *replayFilter
+
+func newReplayFilter(ttl time.Duration) *replayFilter {
+	return &replayFilter{
+		ttl:     ttl,
+		items:   list.New(),
+		keys:    make(map[string]*list.Element),
+		senders: make(map[string]int),
+	}
+}
+
+// remember message key of sender, error if already received,
+// sender is over quota or too many messages are remembered
+func (r *replayFilter) Add(sender, key string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	for e := r.items.Front(); e != nil && now.After(e.Value.(*replayItem).expire); e = r.items.Front() {
+		r.remove(e)
+	}
+	if _, ok := r.keys[key]; ok {
+		return errors.New("message already received")
+	}
+	if r.senders[sender] >= maxReplayItemsPerSender {
+		return errors.New("too many messages")
+	}
+	if r.items.Len() >= maxReplayItems {
+		return errors.New("too many messages")
+	}
+	r.keys[key] = r.items.PushBack(&replayItem{key: key, sender: sender, expire: now.Add(r.ttl)})
+	r.senders[sender]++
+	return nil
+}
+
+func (r *replayFilter) remove(e *list.Element) {
+	item := r.items.Remove(e).(*replayItem)
+	delete(r.keys, item.key)
+	if r.senders[item.sender]--; r.senders[item.sender] <= 0 {
+		delete(r.senders, item.sender)
+	}
+}
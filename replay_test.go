@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReplayFilter(t *testing.T) {
+	r := newReplayFilter(time.Hour)
+	if err := r.Add("a", "a/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add("a", "a/1"); err == nil {
+		t.Error("replayed message accepted")
+	}
+	if err := r.Add("b", "b/1"); err != nil {
+		t.Errorf("message of another sender rejected: %v", err)
+	}
+}
+
+func TestReplayFilterSenderQuota(t *testing.T) {
+	r := newReplayFilter(time.Hour)
+	for i := 0; i < maxReplayItemsPerSender; i++ {
+		if err := r.Add("a", fmt.Sprintf("a/%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Add("a", "a/over"); err == nil {
+		t.Error("message of sender over quota accepted")
+	}
+	if err := r.Add("b", "b/1"); err != nil {
+		t.Errorf("message of another sender rejected: %v", err)
+	}
+}
+
+func TestReplayFilterFull(t *testing.T) {
+	r := newReplayFilter(time.Hour)
+	if err := r.Add("victim", "victim/1"); err != nil {
+		t.Fatal(err)
+	}
+	// flood from fresh senders until full
+	for i := 1; i < maxReplayItems; i++ {
+		sender := fmt.Sprintf("flood%v", i)
+		if err := r.Add(sender, sender+"/1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Add("new", "new/1"); err == nil {
+		t.Error("message accepted while full")
+	}
+	// messages in window are never forgotten to make room
+	if err := r.Add("victim", "victim/1"); err == nil {
+		t.Error("message in window replayed after the cache filled")
+	}
+}
+
+func TestReplayFilterExpire(t *testing.T) {
+	r := newReplayFilter(10 * time.Millisecond)
+	for i := 0; i < maxReplayItemsPerSender; i++ {
+		if err := r.Add("a", fmt.Sprintf("a/%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := r.Add("a", "a/new"); err != nil {
+		t.Errorf("message rejected after old ones expired: %v", err)
+	}
+	if r.items.Len() != 1 || r.senders["a"] != 1 {
+		t.Errorf("expired messages kept: %v items, %v of sender", r.items.Len(), r.senders["a"])
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+const (
+	kBucketSize = 20  // K, max contacts per bucket
+	kIdBits     = 160 // B, bits of node id
+	kAlpha      = 3   // ALPHA, parallel requests of a lookup
+	// contacts seen while a bucket is full, kept to replace
+	// contacts evicted from the bucket
+	kReplacementSize = 8
+)
+
+// RoutingTable kademlia k-buckets keyed by XOR distance to our node id.
+// contacts in a bucket are ordered from least to most recently seen.
+type RoutingTable struct {
+	self    []byte
+	lock    sync.RWMutex
+	buckets [kIdBits][]msg.Contact
+	// most recently seen last, see kReplacementSize
+	replacements [kIdBits][]msg.Contact
+	// ping the least recently seen contact of a full bucket,
+	// it's evicted on error. one ping at a time per bucket
+	ping    func(msg.Contact) error
+	pinging [kIdBits]bool
+}
+
+func NewRoutingTable(nodeId string, ping func(msg.Contact) error) *RoutingTable {
+	self, _ := hex.DecodeString(nodeId)
+	return &RoutingTable{
+		self: self,
+		ping: ping,
+	}
+}
+
+// decode node id, nil if not a valid 160-bit hex string
+func decodeNodeId(nodeId string) []byte {
+	id, err := hex.DecodeString(nodeId)
+	if err != nil || len(id) != kIdBits/8 {
+		return nil
+	}
+	return id
+}
+
+func xorDistance(a, b []byte) []byte {
+	d := make([]byte, len(a))
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// index of the bucket for id, which is the position of the highest
+// bit differing from our node id. -1 if id is our node id.
+func (rt *RoutingTable) bucketIndex(id []byte) int {
+	d := xorDistance(rt.self, id)
+	for i, b := range d {
+		if b == 0 {
+			continue
+		}
+		for j := 7; j >= 0; j-- {
+			if b&(1<<uint(j)) != 0 {
+				return (len(d)-1-i)*8 + j
+			}
+		}
+	}
+	return -1
+}
+
+// add contact or mark it as most recently seen
+func (rt *RoutingTable) Update(c msg.Contact) {
+	id := decodeNodeId(c.NodeID)
+	if id == nil || c.Address == "" || c.Port == 0 {
+		return
+	}
+	idx := rt.bucketIndex(id)
+	if idx < 0 {
+		return
+	}
+
+	rt.lock.Lock()
+	bucket := rt.buckets[idx]
+	for i, bc := range bucket {
+		if bc.NodeID == c.NodeID {
+			// move to tail, address may have changed
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			rt.buckets[idx] = append(bucket, c)
+			rt.lock.Unlock()
+			return
+		}
+	}
+	if len(bucket) < kBucketSize {
+		rt.buckets[idx] = append(bucket, c)
+		rt.dropReplacement(idx, c.NodeID)
+		rt.lock.Unlock()
+		return
+	}
+
+	// bucket full, wait for a place in replacements
+	rt.addReplacement(idx, c)
+	if rt.pinging[idx] || rt.ping == nil {
+		rt.lock.Unlock()
+		return
+	}
+	rt.pinging[idx] = true
+	head := bucket[0]
+	rt.lock.Unlock()
+
+	// keep head if still alive, replace it otherwise
+	go func() {
+		alive := rt.ping(head) == nil
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+		rt.pinging[idx] = false
+		if alive {
+			rt.touch(idx, head)
+		} else {
+			rt.remove(idx, head.NodeID)
+		}
+	}()
+}
+
+// move contact c of bucket idx to tail, if still in the bucket
+func (rt *RoutingTable) touch(idx int, c msg.Contact) {
+	bucket := rt.buckets[idx]
+	for i, bc := range bucket {
+		if bc.NodeID == c.NodeID {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			rt.buckets[idx] = append(bucket, bc)
+			return
+		}
+	}
+}
+
+// remember contact c for bucket idx, most recently seen last
+func (rt *RoutingTable) addReplacement(idx int, c msg.Contact) {
+	rt.dropReplacement(idx, c.NodeID)
+	replacements := rt.replacements[idx]
+	if len(replacements) >= kReplacementSize {
+		replacements = replacements[1:]
+	}
+	rt.replacements[idx] = append(replacements, c)
+}
+
+// remove contact of nodeId from bucket idx, and fill the place
+// with the most recently seen replacement
+func (rt *RoutingTable) remove(idx int, nodeId string) {
+	bucket := rt.buckets[idx]
+	for i, bc := range bucket {
+		if bc.NodeID == nodeId {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			if n := len(rt.replacements[idx]); n > 0 {
+				bucket = append(bucket, rt.replacements[idx][n-1])
+				rt.replacements[idx] = rt.replacements[idx][:n-1]
+			}
+			rt.buckets[idx] = bucket
+			return
+		}
+	}
+	rt.dropReplacement(idx, nodeId)
+}
+
+func (rt *RoutingTable) dropReplacement(idx int, nodeId string) {
+	replacements := rt.replacements[idx]
+	for i, rc := range replacements {
+		if rc.NodeID == nodeId {
+			rt.replacements[idx] = append(replacements[:i], replacements[i+1:]...)
+			return
+		}
+	}
+}
+
+func (rt *RoutingTable) Remove(nodeId string) {
+	id := decodeNodeId(nodeId)
+	if id == nil {
+		return
+	}
+	idx := rt.bucketIndex(id)
+	if idx < 0 {
+		return
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.remove(idx, nodeId)
+}
+
+// at most n contacts closest to key, excluding contact of nodeId exclude
+func (rt *RoutingTable) Nearest(key string, n int, exclude string) []msg.Contact {
+	kid := decodeNodeId(key)
+	if kid == nil {
+		return []msg.Contact{}
+	}
+
+	rt.lock.RLock()
+	contacts := make([]msg.Contact, 0, rt.size())
+	for _, bucket := range rt.buckets {
+		for _, c := range bucket {
+			if c.NodeID != exclude {
+				contacts = append(contacts, c)
+			}
+		}
+	}
+	rt.lock.RUnlock()
+
+	sortByDistance(contacts, kid)
+	if len(contacts) > n {
+		contacts = contacts[:n]
+	}
+	return contacts
+}
+
+// number of contacts
+func (rt *RoutingTable) Size() int {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+	return rt.size()
+}
+
+func (rt *RoutingTable) size() int {
+	size := 0
+	for _, bucket := range rt.buckets {
+		size += len(bucket)
+	}
+	return size
+}
+
+// sort contacts by XOR distance to key, closest first
+func sortByDistance(contacts []msg.Contact, key []byte) {
+	sort.SliceStable(contacts, func(i, j int) bool {
+		di := xorDistance(decodeNodeId(contacts[i].NodeID), key)
+		dj := xorDistance(decodeNodeId(contacts[j].NodeID), key)
+		return bytes.Compare(di, dj) < 0
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+const testSelfId = "0000000000000000000000000000000000000000"
+
+// contact in bucket 159 of testSelfId
+func testContact(i int) msg.Contact {
+	return msg.Contact{NodeID: fmt.Sprintf("ff%038x", i), Address: "127.0.0.1", Port: 4000}
+}
+
+func bucketIds(rt *RoutingTable, idx int) []string {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+	ids := []string{}
+	for _, c := range rt.buckets[idx] {
+		ids = append(ids, c.NodeID)
+	}
+	return ids
+}
+
+// wait until cond holds or fail
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBucketIndex(t *testing.T) {
+	rt := NewRoutingTable(testSelfId, nil)
+	tests := []struct {
+		nodeId string
+		want   int
+	}{
+		{"0000000000000000000000000000000000000000", -1},
+		{"0000000000000000000000000000000000000001", 0},
+		{"0000000000000000000000000000000000000002", 1},
+		{"0000000000000000000000000000000000000003", 1},
+		{"0000000000000000000000000000000000000080", 7},
+		{"0000000000000000000000000000000000000100", 8},
+		{"4000000000000000000000000000000000000000", 158},
+		{"8000000000000000000000000000000000000000", 159},
+		{"ffffffffffffffffffffffffffffffffffffffff", 159},
+	}
+	for _, tt := range tests {
+		if got := rt.bucketIndex(decodeNodeId(tt.nodeId)); got != tt.want {
+			t.Errorf("bucketIndex(%v) = %v, want %v", tt.nodeId, got, tt.want)
+		}
+	}
+}
+
+func TestRoutingTableUpdateMovesToTail(t *testing.T) {
+	rt := NewRoutingTable(testSelfId, nil)
+	for i := 0; i < 3; i++ {
+		rt.Update(testContact(i))
+	}
+	moved := testContact(0)
+	moved.Address = "127.0.0.2"
+	rt.Update(moved)
+	want := []string{testContact(1).NodeID, testContact(2).NodeID, testContact(0).NodeID}
+	if got := bucketIds(rt, 159); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("bucket %v, want %v", got, want)
+	}
+	if c := rt.buckets[159][2]; c.Address != "127.0.0.2" {
+		t.Errorf("address %v not updated", c.Address)
+	}
+	if rt.Size() != 3 {
+		t.Errorf("size %v, want 3", rt.Size())
+	}
+}
+
+func TestRoutingTableIgnoresInvalid(t *testing.T) {
+	rt := NewRoutingTable(testSelfId, nil)
+	rt.Update(msg.Contact{NodeID: testSelfId, Address: "127.0.0.1", Port: 4000})
+	rt.Update(msg.Contact{NodeID: "not hex", Address: "127.0.0.1", Port: 4000})
+	rt.Update(msg.Contact{NodeID: testContact(0).NodeID, Port: 4000})
+	if rt.Size() != 0 {
+		t.Errorf("size %v, want 0", rt.Size())
+	}
+}
+
+func TestRoutingTableFullBucketEvictsDeadHead(t *testing.T) {
+	var pings int32
+	release := make(chan struct{})
+	rt := NewRoutingTable(testSelfId, func(c msg.Contact) error {
+		atomic.AddInt32(&pings, 1)
+		<-release
+		return errors.New("no response")
+	})
+	for i := 0; i < kBucketSize; i++ {
+		rt.Update(testContact(i))
+	}
+	head := testContact(0).NodeID
+
+	// newcomers wait in replacements while the head is pinged once
+	n := kBucketSize + kReplacementSize + 2
+	for i := kBucketSize; i < n; i++ {
+		rt.Update(testContact(i))
+	}
+	waitFor(t, "ping", func() bool { return atomic.LoadInt32(&pings) == 1 })
+	rt.lock.RLock()
+	replacements := len(rt.replacements[159])
+	newest := rt.replacements[159][replacements-1].NodeID
+	rt.lock.RUnlock()
+	if replacements != kReplacementSize {
+		t.Errorf("replacements %v, want %v", replacements, kReplacementSize)
+	}
+	if newest != testContact(n-1).NodeID {
+		t.Errorf("newest replacement %v, want %v", newest, testContact(n-1).NodeID)
+	}
+
+	// dead head is replaced by the most recently seen newcomer
+	close(release)
+	waitFor(t, "eviction", func() bool { return bucketIds(rt, 159)[0] != head })
+	ids := bucketIds(rt, 159)
+	if len(ids) != kBucketSize || ids[kBucketSize-1] != testContact(n-1).NodeID {
+		t.Errorf("bucket %v, want newest replacement at tail", ids)
+	}
+	if atomic.LoadInt32(&pings) != 1 {
+		t.Errorf("%v pings, want 1", atomic.LoadInt32(&pings))
+	}
+}
+
+func TestRoutingTableFullBucketKeepsLiveHead(t *testing.T) {
+	var pings int32
+	rt := NewRoutingTable(testSelfId, func(c msg.Contact) error {
+		atomic.AddInt32(&pings, 1)
+		return nil
+	})
+	for i := 0; i <= kBucketSize; i++ {
+		rt.Update(testContact(i))
+	}
+	head := testContact(0).NodeID
+	waitFor(t, "ping", func() bool {
+		ids := bucketIds(rt, 159)
+		return ids[len(ids)-1] == head
+	})
+	ids := bucketIds(rt, 159)
+	if len(ids) != kBucketSize {
+		t.Errorf("bucket size %v, want %v", len(ids), kBucketSize)
+	}
+	for _, id := range ids {
+		if id == testContact(kBucketSize).NodeID {
+			t.Error("newcomer added while head is alive")
+		}
+	}
+}
+
+func TestRoutingTableRemoveRefills(t *testing.T) {
+	rt := NewRoutingTable(testSelfId, func(c msg.Contact) error {
+		return nil
+	})
+	for i := 0; i <= kBucketSize; i++ {
+		rt.Update(testContact(i))
+	}
+	waitFor(t, "ping", func() bool {
+		rt.lock.RLock()
+		defer rt.lock.RUnlock()
+		return !rt.pinging[159]
+	})
+	rt.Remove(testContact(5).NodeID)
+	ids := bucketIds(rt, 159)
+	if len(ids) != kBucketSize || ids[len(ids)-1] != testContact(kBucketSize).NodeID {
+		t.Errorf("bucket %v, want replacement at tail", ids)
+	}
+	if len(rt.replacements[159]) != 0 {
+		t.Errorf("replacement not taken")
+	}
+}
+
+func TestRoutingTableNearest(t *testing.T) {
+	rt := NewRoutingTable(testSelfId, nil)
+	ids := []string{
+		"8000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000f00",
+		"00000000000000000000000000000000000000f0",
+		"0000000000000000000000000000000000000003",
+	}
+	for _, id := range ids {
+		rt.Update(msg.Contact{NodeID: id, Address: "127.0.0.1", Port: 4000})
+	}
+	got := []string{}
+	for _, c := range rt.Nearest("0000000000000000000000000000000000000002", 3, ids[4]) {
+		got = append(got, c.NodeID)
+	}
+	want := []string{ids[1], ids[3], ids[2]}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Nearest = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/GenaroNetwork/go-farmer/config"
+	"github.com/GenaroNetwork/go-farmer/crypto"
+)
+
+var ErrShardNotFound = errors.New("shard not found")
+var ErrShardExist = errors.New("shard already exist")
+
+// ShardReader content of a stored shard
+type ShardReader interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// ShardStore storage backend of shards, keyed by data_hash
+type ShardStore interface {
+	// save shard read from r. the shard is saved only if r is
+	// read til io.EOF without error, nothing is left otherwise.
+	Put(dataHash string, r io.Reader) (int64, error)
+	Get(dataHash string) (ShardReader, error)
+	// size of the shard
+	Stat(dataHash string) (int64, error)
+	Delete(dataHash string) error
+	// data_hash of all the shards
+	List() ([]string, error)
+	// total size of all the shards
+	Size() (int64, error)
+}
+
+// shardImporter is optionally implemented by a ShardStore which can
+// take a verified file at fPath as the shard without copying, e.g. by
+// rename. shards are saved by Put otherwise.
+type shardImporter interface {
+	Import(dataHash string, fPath string) error
+}
+
+// create shard store configured by shard_store
+func NewShardStore(cfg config.Config) (ShardStore, error) {
+	switch cfg.ShardStore {
+	case "", "flat":
+		return NewFlatShardStore(cfg.GetShardsPath(), cfg.GetTmpPath()), nil
+	default:
+		return nil, fmt.Errorf("unknown shard_store: %v", cfg.ShardStore)
+	}
+}
+
+// flatShardStore saves every shard as a file named by its data_hash
+// in a single directory. shards are written in tmpDir first, which
+// should be on the same filesystem.
+type flatShardStore struct {
+	dir    string
+	tmpDir string
+}
+
+func NewFlatShardStore(dir, tmpDir string) ShardStore {
+	return &flatShardStore{
+		dir:    dir,
+		tmpDir: tmpDir,
+	}
+}
+
+func (s *flatShardStore) path(dataHash string) string {
+	return path.Join(s.dir, dataHash)
+}
+
+func (s *flatShardStore) Put(dataHash string, r io.Reader) (int64, error) {
+	if _, err := s.Stat(dataHash); err == nil {
+		return 0, ErrShardExist
+	}
+	fHandle, err := ioutil.TempFile(s.tmpDir, dataHash)
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := fHandle.Name()
+	size, err := io.Copy(fHandle, r)
+	cErr := fHandle.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path(dataHash))
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+	return size, nil
+}
+
+// move the verified file fPath into place
+func (s *flatShardStore) Import(dataHash string, fPath string) error {
+	if _, err := s.Stat(dataHash); err == nil {
+		return ErrShardExist
+	}
+	return os.Rename(fPath, s.path(dataHash))
+}
+
+func (s *flatShardStore) Get(dataHash string) (ShardReader, error) {
+	fHandle, err := os.Open(s.path(dataHash))
+	if os.IsNotExist(err) {
+		return nil, ErrShardNotFound
+	}
+	return fHandle, err
+}
+
+func (s *flatShardStore) Stat(dataHash string) (int64, error) {
+	fInfo, err := os.Stat(s.path(dataHash))
+	if os.IsNotExist(err) {
+		return 0, ErrShardNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fInfo.Size(), nil
+}
+
+func (s *flatShardStore) Delete(dataHash string) error {
+	err := os.Remove(s.path(dataHash))
+	if os.IsNotExist(err) {
+		return ErrShardNotFound
+	}
+	return err
+}
+
+func (s *flatShardStore) List() ([]string, error) {
+	fInfos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	dataHashes := make([]string, 0, len(fInfos))
+	for _, fInfo := range fInfos {
+		if fInfo.Mode().IsRegular() {
+			dataHashes = append(dataHashes, fInfo.Name())
+		}
+	}
+	return dataHashes, nil
+}
+
+func (s *flatShardStore) Size() (int64, error) {
+	fInfos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64 = 0
+	for _, fInfo := range fInfos {
+		if fInfo.Mode().IsRegular() {
+			size += fInfo.Size()
+		}
+	}
+	return size, nil
+}
+
+// shardVerifier returns an error instead of io.EOF if
+// the content read does not match data_hash and size
+type shardVerifier struct {
+	r        io.Reader
+	h        hash.Hash
+	n        int64
+	size     int64
+	dataHash string
+}
+
+func newShardVerifier(r io.Reader, dataHash string, size int64) io.Reader {
+	return &shardVerifier{
+		r:        r,
+		h:        sha256.New(),
+		size:     size,
+		dataHash: dataHash,
+	}
+}
+
+func (v *shardVerifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.n += int64(n)
+	v.h.Write(p[:n])
+	if v.n > v.size {
+		return n, fmt.Errorf("size exceeds data_size %v", v.size)
+	}
+	if err == io.EOF {
+		if v.n != v.size {
+			return n, fmt.Errorf("size %v does not match data_size %v", v.n, v.size)
+		}
+		if hex.EncodeToString(crypto.Ripemd160(v.h.Sum(nil))) != v.dataHash {
+			return n, errors.New("content does not match data_hash")
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+
+	"github.com/GenaroNetwork/go-farmer/crypto"
+)
+
+func TestShardVerifier(t *testing.T) {
+	content := []byte("shard content")
+	dataHash := hex.EncodeToString(crypto.Ripemd160Sha256(content))
+	size := int64(len(content))
+
+	if _, err := ioutil.ReadAll(newShardVerifier(bytes.NewReader(content), dataHash, size)); err != nil {
+		t.Errorf("matching shard rejected: %v", err)
+	}
+	tests := []struct {
+		name     string
+		content  []byte
+		dataHash string
+		size     int64
+	}{
+		{"other content", []byte("shard c0ntent"), dataHash, size},
+		{"short", content[:size-1], dataHash, size},
+		{"long", append(append([]byte{}, content...), '!'), dataHash, size},
+		{"other data_hash", content, hex.EncodeToString(crypto.Ripemd160Sha256([]byte("other"))), size},
+	}
+	for _, tt := range tests {
+		if _, err := ioutil.ReadAll(newShardVerifier(bytes.NewReader(tt.content), tt.dataHash, tt.size)); err == nil {
+			t.Errorf("%v: shard accepted", tt.name)
+		}
+	}
+}
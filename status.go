@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const statusInterval = time.Minute
+
+// status shown by terminal ui, reported by StatusReporter in daemon mode
+type Status struct {
+	UpTime       int64  `json:"up_time"` // seconds
+	SharedSize   int64  `json:"shared_size"`
+	Network      string `json:"network"`
+	UploadRate   int64  `json:"upload_rate"`   // bytes per second
+	DownloadRate int64  `json:"download_rate"` // bytes per second
+	Updated      int64  `json:"updated"`       // unix time
+}
+
+// storage usage of data_dir
+type storageUsage struct {
+	Contracts     int64
+	ContractBytes int64 // data_size of contracts
+	Used          int64 // size of shards stored
+	Allocated     int64 // storage_allocation, 0 if unlimited
+	Free          int64 // free space of disk
+}
+
+func getStorageUsage() (storageUsage, error) {
+	usage := storageUsage{Allocated: Cfg.GetStorageAllocation()}
+	err := BoltDB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(BucketContract)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var sItem storageItem
+			if err := json.Unmarshal(v, &sItem); err == nil {
+				usage.Contracts++
+				usage.ContractBytes += int64(sItem.Contract.DataSize)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return usage, err
+	}
+	if usage.Used, err = Shards.Size(); err != nil {
+		return usage, err
+	}
+	usage.Free, err = diskFree(Cfg.DataDir)
+	return usage, err
+}
+
+// collect status of node until ctx is done, log it and
+// save it in the status file periodically
+func StatusReporter(ctx context.Context, node INode) {
+	logger := logger.New("subject", "status")
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	last := start
+	lastUp, lastDown := uploadMeter.Total(), downloadMeter.Total()
+	var status Status
+	for {
+		select {
+		case <-ctx.Done():
+			_ = os.Remove(Cfg.GetStatusPath())
+			return
+		case t := <-ticker.C:
+			if size, err := Shards.Size(); err == nil {
+				status.SharedSize = size
+			} else {
+				logger.Warn("get shared size error", "error", err)
+			}
+			status.Network = node.NetState().String()
+			up, down := uploadMeter.Total(), downloadMeter.Total()
+			secs := t.Sub(last).Seconds()
+			status.UpTime = int64(t.Sub(start).Seconds())
+			status.UploadRate = int64(float64(up-lastUp) / secs)
+			status.DownloadRate = int64(float64(down-lastDown) / secs)
+			status.Updated = t.Unix()
+			last, lastUp, lastDown = t, up, down
+		}
+
+		logger.Info("status", "up_time", humanizeDur(time.Duration(status.UpTime)*time.Second),
+			"shared_size", humanizeSize(status.SharedSize), "network", status.Network,
+			"upload", humanizeSize(status.UploadRate)+"/s", "download", humanizeSize(status.DownloadRate)+"/s")
+		if err := saveStatus(status); err != nil {
+			logger.Warn("save status file error", "error", err)
+		}
+	}
+}
+
+// write status file atomically, so readers never see a partial file
+func saveStatus(status Status) error {
+	js, _ := json.MarshalIndent(status, "", " ")
+	tmpPath := Cfg.GetStatusPath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, js, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, Cfg.GetStatusPath())
+}
+
+func writePidFile(pidFile string) error {
+	return ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
@@ -2,60 +2,300 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
 	ui "github.com/gizak/termui"
 )
 
-func UiSetup(chanSize chan int64) (err error) {
+const (
+	// storage usage is scanned at this interval, or after size changed
+	dashboardUsageInterval = 10 * time.Second
+	// points of throughput sparklines, one per second
+	dashboardSparkPoints = 120
+	dashboardAuditLines  = 6
+	dashboardLogLines    = 10
+)
+
+// dashboard widgets and the state they show
+type dashboard struct {
+	node  INode
+	start time.Time
+
+	status  *ui.Par
+	storage *ui.Gauge
+	traffic *ui.Sparklines
+	audits  *ui.List
+	mirrors *ui.List
+	logs    *ui.List
+
+	network    NetState
+	usage      storageUsage
+	usageAt    time.Time
+	usageDirty bool
+
+	lastTick         time.Time
+	lastUp, lastDown int64
+	upRates          []int
+	downRates        []int
+
+	auditPassed, auditFailed int
+	recentAudits             []string
+	activeMirrors            map[string]EventMirror
+	recentLogs               []string
+}
+
+func newDashboard(node INode) *dashboard {
+	d := &dashboard{
+		node:          node,
+		start:         time.Now(),
+		network:       node.NetState(),
+		activeMirrors: make(map[string]EventMirror),
+	}
+
+	d.status = ui.NewPar("")
+	d.status.BorderLabel = "Status"
+	d.status.BorderFg = ui.ColorYellow
+	d.status.Height = 7
+	d.status.PaddingLeft = 1
+
+	d.storage = ui.NewGauge()
+	d.storage.BorderLabel = "Storage"
+	d.storage.BorderFg = ui.ColorYellow
+	d.storage.BarColor = ui.ColorGreen
+	d.storage.Height = 7
+
+	up := ui.NewSparkline()
+	up.LineColor = ui.ColorGreen
+	up.Height = 3
+	down := ui.NewSparkline()
+	down.LineColor = ui.ColorCyan
+	down.Height = 3
+	d.traffic = ui.NewSparklines(up, down)
+	d.traffic.BorderLabel = "Throughput"
+	d.traffic.BorderFg = ui.ColorYellow
+	d.traffic.Height = 10
+
+	d.audits = ui.NewList()
+	d.audits.BorderFg = ui.ColorYellow
+	d.audits.Height = dashboardAuditLines + 2
+	d.audits.PaddingLeft = 1
+
+	d.mirrors = ui.NewList()
+	d.mirrors.BorderLabel = "Mirrors"
+	d.mirrors.BorderFg = ui.ColorYellow
+	d.mirrors.Height = dashboardAuditLines + 2
+	d.mirrors.PaddingLeft = 1
+
+	d.logs = ui.NewList()
+	d.logs.BorderLabel = "Log :PRESS q to quit"
+	d.logs.BorderFg = ui.ColorYellow
+	d.logs.Height = dashboardLogLines + 2
+	d.logs.PaddingLeft = 1
+
+	// mirror jobs resumed on start
+	if jobs, err := listMirrorJobs(); err == nil {
+		for _, job := range jobs {
+			if job.State == MirrorPending || job.State == MirrorRunning {
+				d.activeMirrors[job.DataHash] = EventMirror{
+					DataHash: job.DataHash,
+					State:    job.State,
+					Attempts: job.Attempts,
+				}
+			}
+		}
+	}
+	d.usageDirty = true
+	return d
+}
+
+func (d *dashboard) layout() {
+	ui.Body.AddRows(
+		ui.NewRow(
+			ui.NewCol(6, 0, d.status),
+			ui.NewCol(6, 0, d.storage)),
+		ui.NewRow(
+			ui.NewCol(12, 0, d.traffic)),
+		ui.NewRow(
+			ui.NewCol(6, 0, d.audits),
+			ui.NewCol(6, 0, d.mirrors)),
+		ui.NewRow(
+			ui.NewCol(12, 0, d.logs)))
+	ui.Body.Width = ui.TermWidth()
+	ui.Body.Align()
+}
+
+// keep the last n items of lines
+func appendLine(lines []string, line string, n int) []string {
+	lines = append(lines, line)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func (d *dashboard) handleEvent(e Event) {
+	switch e := e.(type) {
+	case EventNetState:
+		d.network = e.State
+	case EventShardStored, EventShardDeleted:
+		d.usageDirty = true
+	case EventAudit:
+		line := "[PASS](fg-green) " + e.DataHash
+		if e.Passed {
+			d.auditPassed++
+		} else {
+			d.auditFailed++
+			line = "[FAIL](fg-red) " + e.DataHash + " " + e.Error
+		}
+		d.recentAudits = appendLine(d.recentAudits, line, dashboardAuditLines)
+	case EventMirror:
+		if e.State == MirrorPending || e.State == MirrorRunning {
+			d.activeMirrors[e.DataHash] = e
+		} else {
+			delete(d.activeMirrors, e.DataHash)
+		}
+	case EventLog:
+		line := e.Time.Format("15:04:05") + " " + e.Level + " " + e.Text
+		d.recentLogs = appendLine(d.recentLogs, line, dashboardLogLines)
+	}
+}
+
+// update widgets every second
+func (d *dashboard) tick(t time.Time) {
+	// throughput
+	up, down := uploadMeter.Total(), downloadMeter.Total()
+	if !d.lastTick.IsZero() {
+		secs := t.Sub(d.lastTick).Seconds()
+		d.upRates = append(d.upRates, int(float64(up-d.lastUp)/secs))
+		d.downRates = append(d.downRates, int(float64(down-d.lastDown)/secs))
+		if len(d.upRates) > dashboardSparkPoints {
+			d.upRates = d.upRates[1:]
+			d.downRates = d.downRates[1:]
+		}
+	}
+	d.lastTick, d.lastUp, d.lastDown = t, up, down
+	lastRate := func(rates []int) int64 {
+		if len(rates) == 0 {
+			return 0
+		}
+		return int64(rates[len(rates)-1])
+	}
+	d.traffic.Lines[0].Data = d.upRates
+	d.traffic.Lines[0].Title = fmt.Sprintf("Upload: %v/s", humanizeSize(lastRate(d.upRates)))
+	d.traffic.Lines[1].Data = d.downRates
+	d.traffic.Lines[1].Title = fmt.Sprintf("Download: %v/s", humanizeSize(lastRate(d.downRates)))
+
+	// storage
+	if d.usageDirty || t.Sub(d.usageAt) >= dashboardUsageInterval {
+		if usage, err := getStorageUsage(); err == nil {
+			d.usage = usage
+		}
+		d.usageAt = t
+		d.usageDirty = false
+	}
+	capacity := d.usage.Allocated
+	if capacity <= 0 {
+		// unlimited, the disk is the limit
+		capacity = d.usage.Used + d.usage.Free
+	}
+	if capacity > 0 {
+		d.storage.Percent = int(d.usage.Used * 100 / capacity)
+	}
+	allocated := "unlimited"
+	if d.usage.Allocated > 0 {
+		allocated = humanizeSize(d.usage.Allocated)
+	}
+	d.storage.Label = fmt.Sprintf("{{percent}}%% used %v / allocated %v / disk free %v",
+		humanizeSize(d.usage.Used), allocated, humanizeSize(d.usage.Free))
+
+	// status
+	networkColor := "fg-green"
+	if d.network != NetConnected {
+		networkColor = "fg-red"
+	}
+	d.status.Text = fmt.Sprintf("Up Time: %v\nNetwork: [%v](%v)\nSeeds: %v  Peers: %v\nContracts: %v (%v)\nShared Size: %v",
+		humanizeDur(t.Sub(d.start)), d.network, networkColor,
+		len(Cfg.GetSeedList()), d.node.Peers(),
+		d.usage.Contracts, humanizeSize(d.usage.ContractBytes), humanizeSize(d.usage.Used))
+
+	// audits, latest first
+	d.audits.BorderLabel = fmt.Sprintf("Audits: %v passed, %v failed", d.auditPassed, d.auditFailed)
+	d.audits.Items = reversed(d.recentAudits)
+
+	// mirrors
+	dataHashes := make([]string, 0, len(d.activeMirrors))
+	for dataHash := range d.activeMirrors {
+		dataHashes = append(dataHashes, dataHash)
+	}
+	sort.Strings(dataHashes)
+	items := make([]string, 0, len(dataHashes))
+	for _, dataHash := range dataHashes {
+		m := d.activeMirrors[dataHash]
+		items = append(items, fmt.Sprintf("%v %v attempt %v", dataHash, m.State, m.Attempts))
+	}
+	d.mirrors.Items = items
+
+	d.logs.Items = d.recentLogs
+}
+
+func reversed(lines []string) []string {
+	r := make([]string, len(lines))
+	for i, line := range lines {
+		r[len(lines)-1-i] = line
+	}
+	return r
+}
+
+// run terminal dashboard until q is pressed, driven by the event bus
+func UiSetup(node INode) (err error) {
 	err = ui.Init()
 	if err != nil {
 		return
 	}
 	defer ui.Close()
 
-	strs := [] string{
-		"Up Time: 0 second",
-		"Shared Size: 0",
-		":PRESS q to quit",
-	}
+	events, unsubscribe := Events.Subscribe(1000)
+	defer unsubscribe()
 
-	ls := ui.NewList()
-	ls.Items = strs
-	ls.Width = 70
-	ls.Height = len(strs) + 2
-	ls.BorderLabel = "Status"
-	ls.BorderFg = ui.ColorYellow
-	ls.PaddingLeft = 1
+	d := newDashboard(node)
+	d.layout()
+	d.tick(time.Now())
+	ui.Render(ui.Body)
 
-	ui.Render(ls)
 	ticker := time.NewTicker(time.Second)
-	now := time.Now()
+	defer ticker.Stop()
+	stop := make(chan struct{})
+	defer close(stop)
 	go func() {
-		var totalSize int64 = 0
-		var t time.Time
 		for {
-		inner:
-			for {
-				select {
-				case size := <-chanSize:
-					totalSize += size
-				case t = <-ticker.C:
-					break inner
-				}
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				d.handleEvent(e)
+				continue
+			case t := <-ticker.C:
+				d.tick(t)
 			}
-			dur := t.Sub(now)
-			strs[0] = fmt.Sprintf("Up Time: %v", humanizeDur(dur))
-			strs[1] = fmt.Sprintf("Shared Size: %v", humanizeSize(totalSize))
-
-			ui.Render(ls)
+			ui.Render(ui.Body)
 		}
 	}()
 
+	ui.Handle("/sys/wnd/resize", func(ui.Event) {
+		ui.Body.Width = ui.TermWidth()
+		ui.Body.Align()
+		ui.Clear()
+		ui.Render(ui.Body)
+	})
 	ui.Handle("/sys/kbd/q", func(ui.Event) {
 		ui.StopLoop()
 	})
+	// terminal is in raw mode, ctrl-c is not a signal
+	ui.Handle("/sys/kbd/C-c", func(ui.Event) {
+		ui.StopLoop()
+	})
 	ui.Loop()
 	return
 }
@@ -130,5 +370,5 @@ func humanizeSize(size int64) string {
 
 	// TB
 	s = float64(s) / 1024
-	return strconv.FormatFloat(s, ffmt, prec, bitSize) + " GB"
+	return strconv.FormatFloat(s, ffmt, prec, bitSize) + " TB"
 }
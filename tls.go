@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/crypto"
+	"github.com/GenaroNetwork/go-farmer/msg"
+	"github.com/patrickmn/go-cache"
+)
+
+const tlsCertValidity = 10 * 365 * 24 * time.Hour
+
+// URI scheme of the certificate subject alternative name holding the node
+// key signature of the sha256 hash of the certificate public key, binding
+// the tls key to the node id. a URI name needs no registered object
+// identifier, unlike a private certificate extension.
+const nodeSignatureScheme = "genaro-node"
+
+// certificate of the https server: configured by tls_cert and tls_key,
+// or self-signed and bound to the node key when tls_auto is set
+func LoadTLSCertificate(pk crypto.PrivateKey) (tls.Certificate, error) {
+	if Cfg.TLSCert != "" {
+		return tls.LoadX509KeyPair(Cfg.TLSCert, Cfg.TLSKey)
+	}
+
+	certPath := path.Join(Cfg.GetTLSPath(), "cert.pem")
+	keyPath := path.Join(Cfg.GetTLSPath(), "key.pem")
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		// regenerate if node key changed
+		x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Before(x509Cert.NotAfter) {
+			if nodeId, err := certNodeId(x509Cert); err == nil && nodeId == pk.NodeId() {
+				return cert, nil
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return cert, err
+	}
+	if err := generateTLSCertificate(pk, certPath, keyPath); err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate certificate error: %v", err)
+	}
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+func generateTLSCertificate(pk crypto.PrivateKey, certPath, keyPath string) error {
+	if err := os.MkdirAll(path.Dir(certPath), 0700); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(spki)
+	sig := pk.Sign(hash[:])
+	if sig == nil {
+		return errors.New("sign certificate key error")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: pk.NodeId()},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(tlsCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		URIs:         []*url.URL{{Scheme: nodeSignatureScheme, Opaque: hex.EncodeToString(sig)}},
+	}
+	if ip := net.ParseIP(Cfg.GetLocalAddr()); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(keyPath, keyPem, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certPath, certPem, 0644)
+}
+
+// base url of contact, https if contact announces tls
+func contactUrl(c msg.Contact) string {
+	scheme := "http"
+	if c.IsTLS() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%v://%v:%v", scheme, c.Address, c.Port)
+}
+
+// transports of https contacts by node id and address, each verifying
+// its contact. dropped after a while, closing their idle connections.
+var tlsTransports = cache.New(10*time.Minute, 10*time.Minute)
+
+func init() {
+	tlsTransports.OnEvicted(func(_ string, t interface{}) {
+		t.(*http.Transport).CloseIdleConnections()
+	})
+}
+
+// transport of requests to contact c made from base. https contacts get
+// their own transport, which checks the peer certificate during the
+// handshake, before any request is sent.
+func contactTransport(base *http.Transport, name string, c msg.Contact) *http.Transport {
+	if !c.IsTLS() {
+		return base
+	}
+	key := fmt.Sprintf("%v/%v@%v", name, c.NodeID, c.Address)
+	if t, ok := tlsTransports.Get(key); ok {
+		return t.(*http.Transport)
+	}
+	t := base.Clone()
+	t.TLSClientConfig = peerTLSConfig(c)
+	tlsTransports.SetDefault(key, t)
+	return t
+}
+
+// peers are authenticated by checkPeerCertificate: by the node signature
+// of self-signed certificates, or by certificate authorities otherwise
+func peerTLSConfig(c msg.Contact) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return checkPeerCertificate(cs.PeerCertificates, c)
+		},
+	}
+}
+
+// node id which signed the public key of cert
+func certNodeId(cert *x509.Certificate) (string, error) {
+	for _, u := range cert.URIs {
+		if u.Scheme == nodeSignatureScheme {
+			sig, err := hex.DecodeString(u.Opaque)
+			if err != nil {
+				return "", err
+			}
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			return crypto.RecoverNodeId(hash[:], sig)
+		}
+	}
+	return "", errors.New("no node signature")
+}
+
+// check that peer certificates are of the node of contact c
+func checkPeerCertificate(certs []*x509.Certificate, c msg.Contact) error {
+	if len(certs) == 0 {
+		return errors.New("no peer certificate")
+	}
+	if nodeId, err := certNodeId(certs[0]); err == nil {
+		if nodeId != c.NodeID {
+			return errors.New("peer certificate does not match node id")
+		}
+		return nil
+	}
+	// certificate configured by tls_cert, valid for the contact address
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       c.Address,
+		Intermediates: intermediates,
+	})
+	return err
+}
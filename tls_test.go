@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/GenaroNetwork/go-farmer/crypto"
+	"github.com/GenaroNetwork/go-farmer/msg"
+)
+
+func TestPeerCertificate(t *testing.T) {
+	defer setupTestStorage(t)()
+	var pk, other crypto.PrivateKey
+	if err := pk.SetKey(Cfg.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SetKey("1f0e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadTLSCertificate(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	tests := []struct {
+		name   string
+		nodeId string
+		ok     bool
+	}{
+		{"other node", other.NodeId(), false},
+		{"node of certificate", pk.NodeId(), true},
+	}
+	for _, tt := range tests {
+		c := msg.Contact{Address: host, Port: uint16(portNum), NodeID: tt.nodeId, Protocol: "1.2.0" + msg.TLSProtocolSuffix}
+		client := &http.Client{Transport: contactTransport(rpcTransport, "test", c)}
+		before := atomic.LoadInt32(&requests)
+		resp, err := client.Get(contactUrl(c))
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: error %v, want ok %v", tt.name, err, tt.ok)
+		}
+		handled := atomic.LoadInt32(&requests) != before
+		if handled != tt.ok {
+			t.Errorf("%v: request handled %v, want %v", tt.name, handled, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/satori/go.uuid"
+)
+
+// operations allowed by a token
+const (
+	TokenUpload   = "upload"   // CONSIGN
+	TokenDownload = "download" // RETRIEVE, MIRROR
+)
+
+const tokenTTL = 30 * time.Minute
+const tokenSweepInterval = 5 * time.Minute
+
+// token record saved in BucketToken
+type tokenItem struct {
+	DataHash  string `json:"data_hash"`
+	Operation string `json:"operation"`
+	NodeID    string `json:"node_id"` // renter the token issued to
+	Expire    int64  `json:"expire"`  // unix time
+}
+
+func (t *tokenItem) isExpired(now time.Time) bool {
+	return now.Unix() >= t.Expire
+}
+
+// check if token allows operation on dataHash
+func (t *tokenItem) check(dataHash, operation string) error {
+	if t.isExpired(time.Now()) {
+		return errors.New("token expired")
+	}
+	if t.DataHash != dataHash {
+		return errors.New("token is not for data_hash")
+	}
+	if t.Operation != operation {
+		return errors.New("token is not for " + operation)
+	}
+	return nil
+}
+
+// generate and save a token
+func newToken(dataHash, operation, nodeId string) (string, error) {
+	token := hex.EncodeToString(uuid.NewV4().Bytes())
+	tItem := tokenItem{
+		DataHash:  dataHash,
+		Operation: operation,
+		NodeID:    nodeId,
+		Expire:    time.Now().Add(tokenTTL).Unix(),
+	}
+	js, _ := json.Marshal(tItem)
+	if err := BoltDbSet([]byte(token), js, BucketToken, false); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func getToken(token string) (tokenItem, error) {
+	var tItem tokenItem
+	raw, err := BoltDbGet([]byte(token), BucketToken)
+	if err != nil {
+		return tItem, err
+	}
+	err = json.Unmarshal(raw, &tItem)
+	return tItem, err
+}
+
+func deleteToken(token string) error {
+	return BoltDbDelete([]byte(token), BucketToken)
+}
+
+// delete tokens of dataHash, returns number of tokens deleted
+func deleteTokensOf(dataHash string) (int, error) {
+	count := 0
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketToken))
+		if b == nil {
+			return nil
+		}
+		var keys [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var tItem tokenItem
+			if err := json.Unmarshal(v, &tItem); err == nil && tItem.DataHash == dataHash {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// delete expired and malformed tokens, returns number of tokens deleted
+func sweepTokens() (int, error) {
+	count := 0
+	now := time.Now()
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketToken))
+		if b == nil {
+			return nil
+		}
+		var keys [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var tItem tokenItem
+			if err := json.Unmarshal(v, &tItem); err != nil || tItem.isExpired(now) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// purge expired tokens periodically until ctx is done
+func TokenSweeper(ctx context.Context) {
+	logger := logger.New("subject", "token sweeper")
+	ticker := time.NewTicker(tokenSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		count, err := sweepTokens()
+		if err != nil {
+			logger.Warn("sweep tokens error", "error", err)
+			continue
+		}
+		if count > 0 {
+			logger.Info("expired tokens deleted", "count", count)
+		}
+	}
+}
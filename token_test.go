@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testDataHash = "5d41402abc4b2a76b9719d911017c592c4a4d4e2"
+
+func TestTokenCheck(t *testing.T) {
+	valid := tokenItem{
+		DataHash:  testDataHash,
+		Operation: TokenUpload,
+		Expire:    time.Now().Add(time.Hour).Unix(),
+	}
+	if err := valid.check(testDataHash, TokenUpload); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+	tests := []struct {
+		name      string
+		expire    time.Duration
+		dataHash  string
+		operation string
+	}{
+		{"wrong operation", time.Hour, testDataHash, TokenDownload},
+		{"expired", -time.Second, testDataHash, TokenUpload},
+		{"expiring now", 0, testDataHash, TokenUpload},
+		{"other data_hash", time.Hour, "0000000000000000000000000000000000000000", TokenUpload},
+	}
+	for _, tt := range tests {
+		tItem := valid
+		tItem.Expire = time.Now().Add(tt.expire).Unix()
+		if err := tItem.check(tt.dataHash, tt.operation); err == nil {
+			t.Errorf("%v: token accepted", tt.name)
+		}
+	}
+}
+
+func TestSweepTokens(t *testing.T) {
+	defer setupTestStorage(t)()
+	save := func(token string, v []byte) {
+		if err := BoltDbSet([]byte(token), v, BucketToken, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item := func(expire time.Duration) []byte {
+		js, _ := json.Marshal(tokenItem{
+			DataHash:  testDataHash,
+			Operation: TokenDownload,
+			Expire:    time.Now().Add(expire).Unix(),
+		})
+		return js
+	}
+	save("live", item(time.Hour))
+	save("expired", item(-time.Minute))
+	save("malformed", []byte("{"))
+
+	count, err := sweepTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%v tokens deleted, want 2", count)
+	}
+	if _, err := getToken("live"); err != nil {
+		t.Errorf("live token deleted: %v", err)
+	}
+	for _, token := range []string{"expired", "malformed"} {
+		if _, err := BoltDbGet([]byte(token), BucketToken); err == nil {
+			t.Errorf("%v token kept", token)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GenaroNetwork/go-farmer/config"
+)
+
+// seconds for Retry-After of requests rejected by transfer limits
+const transferRetryAfter = "30"
+
+// concurrent shard transfers through ShardHandler. uploads are
+// shards POSTed to us, downloads are shards GET from us.
+var uploadSlots, downloadSlots *transferSlots
+
+func SetupTransferLimits(cfg config.Config) {
+	uploadSlots = newTransferSlots(cfg.MaxUploads)
+	downloadSlots = newTransferSlots(cfg.MaxDownloads)
+}
+
+// transferSlots limits number of concurrent transfers, unlimited if max <= 0
+type transferSlots struct {
+	lock   sync.Mutex
+	max    int
+	active int
+}
+
+func newTransferSlots(max int) *transferSlots {
+	return &transferSlots{max: max}
+}
+
+// take a slot, false if all slots are taken
+func (s *transferSlots) TryAcquire() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.max > 0 && s.active >= s.max {
+		return false
+	}
+	s.active++
+	return true
+}
+
+func (s *transferSlots) Release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.active--
+}
+
+// if there's a free slot
+func (s *transferSlots) Available() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.max <= 0 || s.active < s.max
+}
+
+// number of transfers in progress
+func (s *transferSlots) Active() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.active
+}
+
+// a shard transfer in progress, for the admin API
+type transferInfo struct {
+	DataHash  string `json:"data_hash"`
+	Direction string `json:"direction"` // "upload" or "download"
+	Renter    string `json:"renter"`    // node id the token issued to
+	Started   int64  `json:"started"`   // unix time
+}
+
+var transfers = struct {
+	sync.Mutex
+	next   int
+	active map[int]transferInfo
+}{active: make(map[int]transferInfo)}
+
+// record a transfer in progress, call the returned func when it's done
+func trackTransfer(direction, dataHash, renter string) func() {
+	transfers.Lock()
+	defer transfers.Unlock()
+	id := transfers.next
+	transfers.next++
+	transfers.active[id] = transferInfo{
+		DataHash:  dataHash,
+		Direction: direction,
+		Renter:    renter,
+		Started:   time.Now().Unix(),
+	}
+	return func() {
+		transfers.Lock()
+		defer transfers.Unlock()
+		delete(transfers.active, id)
+	}
+}
+
+// transfers in progress
+func activeTransfers() []transferInfo {
+	transfers.Lock()
+	defer transfers.Unlock()
+	infos := make([]transferInfo, 0, len(transfers.active))
+	for _, info := range transfers.active {
+		infos = append(infos, info)
+	}
+	return infos
+}
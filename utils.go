@@ -2,16 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path"
-	"sync"
 	"time"
 
 	"github.com/GenaroNetwork/go-farmer/msg"
@@ -70,6 +71,18 @@ func BoltDbSet(key, value []byte, bucket string, over bool) error {
 	return err
 }
 
+func BoltDbDelete(key []byte, bucket string) error {
+	err := BoltDB.Update(func(tx *bolt.Tx) error {
+		// ensure bucket
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		return b.Delete(key)
+	})
+	return err
+}
+
 func MagicHash(msg []byte) [32]byte {
 	prefix1 := varintBufNum(len(MagicBytes))
 	prefix2 := varintBufNum(len(msg))
@@ -115,32 +128,43 @@ func varintBufNum(n int) (buf []byte) {
 
 type SendMsgHandler func() error
 
-var clientCache = sync.Map{}
+var rpcTransport = &http.Transport{
+	Proxy:           http.ProxyFromEnvironment,
+	IdleConnTimeout: 90 * time.Second,
+}
 
 func SendMsg(c msg.Contact, m *MsgInOut, dur time.Duration, cb SendMsgHandler) error {
+	return SendMsgContext(context.Background(), c, m, dur, cb)
+}
+
+// SendMsg which is cancelled with ctx
+func SendMsgContext(ctx context.Context, c msg.Contact, m *MsgInOut, dur time.Duration, cb SendMsgHandler) error {
+	start := time.Now()
+	err := sendMsg(ctx, c, m, dur, cb)
+	peer := peerLabel(c)
+	outboundRequests.Inc(peer, resultLabel(err))
+	outboundDuration.ObserveSince(start, peer)
+	return err
+}
+
+func sendMsg(ctx context.Context, c msg.Contact, m *MsgInOut, dur time.Duration, cb SendMsgHandler) error {
 	// prepare request payload
 	msgStr, _ := json.Marshal(m.MsgOutStruct())
 	body := bytes.NewBuffer([]byte(msgStr))
 
 	// prepare request
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%v:%v", c.Address, c.Port), body)
+	req, err := http.NewRequest("POST", contactUrl(c), body)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("userAgent", "8.7.3")
 	req.Header.Set("content-type", "application/json")
 
 	// do send request
-	var client *http.Client
-	if value, ok := clientCache.Load(dur); ok {
-		if c, ok := value.(*http.Client); ok {
-			client = c
-		}
-	}
-	if client == nil {
-		client = &http.Client{}
-		client.Timeout = dur
-		clientCache.Store(dur, client)
+	client := &http.Client{
+		Transport: contactTransport(rpcTransport, "rpc", c),
+		Timeout:   dur,
 	}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -158,67 +182,182 @@ func SendMsg(c msg.Contact, m *MsgInOut, dur time.Duration, cb SendMsgHandler) e
 	return cb()
 }
 
-func DownloadShard(c msg.Contact, dataHash, token string) error {
+const downloadAttempts = 5
+const downloadIdleTimeout = time.Minute
+
+var downloadTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ResponseHeaderTimeout: 30 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+}
+
+// name of the partial download of shard in tmp dir
+func mirrorTmpName(dataHash string) string {
+	return dataHash + ".mirror"
+}
+
+// download shard of size from farmer c into shard store, the partial
+// download is resumed by HTTP Range on retry. the shard is stored only
+// if its content matches dataHash.
+func DownloadShard(ctx context.Context, c msg.Contact, dataHash, token string, size int64, limiters ...*rateLimiter) error {
 	logger := logger.New("subject", "DownloadShard")
 	// check shard existence
-	fPath := path.Join(Cfg.GetShardsPath(), dataHash)
-	_, err := os.Stat(fPath)
-	if os.IsExist(err) {
-		return errors.New("shard already exist")
+	if _, err := Shards.Stat(dataHash); err == nil {
+		return ErrShardExist
+	}
+
+	tmpPath := path.Join(Cfg.GetTmpPath(), mirrorTmpName(dataHash))
+	var err error
+	backoff := time.Second
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				// partial file is kept to resume
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		logger.Info("downloading shard", "data_hash", dataHash, "attempt", attempt)
+		if err = downloadShardPart(ctx, c, dataHash, token, size, tmpPath, limiters); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("download shard error", "data_hash", dataHash, "attempt", attempt, "error", err)
+			continue
+		}
+		if err = verifyShardFile(tmpPath, dataHash, size); err != nil {
+			// broken, download again from start
+			logger.Warn("verify shard error", "data_hash", dataHash, "attempt", attempt, "error", err)
+			_ = os.Remove(tmpPath)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return err
+	}
+
+	// move into shard store
+	if importer, ok := Shards.(shardImporter); ok {
+		err = importer.Import(dataHash, tmpPath)
+	} else {
+		var fHandle *os.File
+		fHandle, err = os.Open(tmpPath)
+		if err == nil {
+			_, err = Shards.Put(dataHash, fHandle)
+			fHandle.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(tmpPath)
+	logger.Info("downloaded shard", "data_hash", dataHash, "size", size)
+	return nil
+}
+
+// download the rest of shard into tmpPath
+func downloadShardPart(ctx context.Context, c msg.Contact, dataHash, token string, size int64, tmpPath string, limiters []*rateLimiter) error {
+	fHandle, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fHandle.Close()
+	fInfo, err := fHandle.Stat()
+	if err != nil {
+		return err
+	}
+	offset := fInfo.Size()
+	if offset > size {
+		offset = 0
+	}
+	if offset == size {
+		return nil
 	}
 
 	// prepare request
-	url := fmt.Sprintf("http://%v:%v/shards/%v?token=%v", c.Address, c.Port, dataHash, token)
+	url := fmt.Sprintf("%v/shards/%v?token=%v", contactUrl(c), dataHash, token)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("userAgent", "8.7.3")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
 
 	// do send request
-	dur := time.Second * 0
-	var client *http.Client
-	if value, ok := clientCache.Load(dur); ok {
-		if c, ok := value.(*http.Client); ok {
-			client = c
-		}
+	client := &http.Client{Transport: contactTransport(downloadTransport, "download", c)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
-	if client == nil {
-		client = &http.Client{}
-		client.Timeout = dur
-		clientCache.Store(dur, client)
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// range not supported, from start
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		_ = fHandle.Truncate(0)
+		return errors.New("range not satisfiable")
+	default:
+		return fmt.Errorf("unexpected status %v", resp.Status)
 	}
-	resp, err := client.Do(req)
+	if err := fHandle.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := fHandle.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// cancel request if no data for a while
+	body := &idleTimeoutReader{
+		r:     resp.Body,
+		timer: time.AfterFunc(downloadIdleTimeout, cancel),
+	}
+	defer body.timer.Stop()
+	limiters = append(limiters, uploadLimiters()...)
+	n, err := io.Copy(fHandle, io.LimitReader(newLimitedReader(body, &uploadMeter, limiters...), size-offset))
 	if err != nil {
 		return err
 	}
+	if offset+n != size {
+		return fmt.Errorf("connection closed at %v of %v", offset+n, size)
+	}
+	return fHandle.Sync()
+}
 
-	// download shard
-	go func(path string) {
-		fHandle, err := os.Create(path)
-		if err != nil {
-			logger.Warn("create shard error", "data_hash", dataHash, "error", err)
-			// if exist, remove the broken file
-			_, err = os.Stat(path)
-			if os.IsExist(err) {
-				rErr := os.Remove(path)
-				if rErr != nil {
-					logger.Warn("remove broken file error", "data_hash", dataHash, "error", rErr)
-				}
-			}
-			return
-		}
-		defer fHandle.Close()
-		logger.Info("downloading shard", "data_hash", dataHash)
-		size, err := io.Copy(fHandle, resp.Body)
-		if err != nil {
-			logger.Warn("download shard error", "data_hash", dataHash, "error", err)
-			return
-		}
-		logger.Info("downloaded shard", "data_hash", dataHash, "size", size)
-		// TODO: update db ?
-	}(fPath)
-	return nil
+// check if content of file fPath matches dataHash and size
+func verifyShardFile(fPath, dataHash string, size int64) error {
+	fHandle, err := os.Open(fPath)
+	if err != nil {
+		return err
+	}
+	defer fHandle.Close()
+	_, err = io.Copy(ioutil.Discard, newShardVerifier(fHandle, dataHash, size))
+	return err
+}
+
+// idleTimeoutReader resets timer on every read
+type idleTimeoutReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.timer.Reset(downloadIdleTimeout)
+	return n, err
 }
 
 func JsonMarshal(v interface{}) string {